@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	ArchiveKeyPrefix  = "archive/"
+	ArchiveDateFormat = "2006-01-02"
+)
+
+// ArchiveRecord は投稿した記事の恒久的な記録。重複判定用の投稿済みURLとは異なり削除しない。
+type ArchiveRecord struct {
+	URL          string    `json:"url"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Source       string    `json:"source"`
+	Prefecture   string    `json:"prefecture,omitempty"`
+	Prefectures  []string  `json:"prefectures,omitempty"`
+	Municipality string    `json:"municipality,omitempty"`
+	Latitude     float64   `json:"latitude,omitempty"`
+	Longitude    float64   `json:"longitude,omitempty"`
+	Location     string    `json:"location,omitempty"`
+	StatusID     string    `json:"status_id,omitempty"`
+	DuplicateOf  string    `json:"duplicate_of,omitempty"`
+	PublishedAt  time.Time `json:"published_at"`
+	PostedAt     time.Time `json:"posted_at"`
+}
+
+func newArchiveRecord(posted PostedURL) ArchiveRecord {
+	return ArchiveRecord{
+		URL:          posted.URL,
+		Title:        posted.Title,
+		Description:  posted.Description,
+		Source:       posted.Source,
+		Prefecture:   posted.Prefecture,
+		Prefectures:  posted.Prefectures,
+		Municipality: posted.Municipality,
+		Latitude:     posted.Latitude,
+		Longitude:    posted.Longitude,
+		Location:     posted.Location,
+		StatusID:     posted.StatusID,
+		DuplicateOf:  posted.DuplicateOf,
+		PublishedAt:  posted.PublishedAt,
+		PostedAt:     posted.PostedAt,
+	}
+}
+
+func archiveKey(date time.Time) string {
+	jst := time.FixedZone("JST", JSTOffset)
+	return ArchiveKeyPrefix + date.In(jst).Format(ArchiveDateFormat) + ".jsonl"
+}
+
+// appendArchiveRecords は投稿日（JST）ごとのJSONLに記録を追記する。同じURLが既にある場合は追記しない。
+func appendArchiveRecords(ctx context.Context, store StateStore, records []ArchiveRecord) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would archive %d records", len(records))
+		return nil
+	}
+
+	recordsByKey := make(map[string][]ArchiveRecord)
+	for _, record := range records {
+		key := archiveKey(record.PostedAt)
+		recordsByKey[key] = append(recordsByKey[key], record)
+	}
+
+	keys := make([]string, 0, len(recordsByKey))
+	for key := range recordsByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := appendArchiveFile(ctx, store, key, recordsByKey[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendArchiveFile(ctx context.Context, store StateStore, key string, records []ArchiveRecord) error {
+	for attempt := 1; attempt <= SaveMaxAttempts; attempt++ {
+		data, version, err := store.Get(ctx, key)
+		if err != nil && !errors.Is(err, ErrStateNotFound) {
+			return fmt.Errorf("failed to load archive '%s': %w", key, err)
+		}
+
+		existing, err := parseArchiveRecords(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse archive '%s': %w", key, err)
+		}
+		archivedURLs := make(map[string]struct{}, len(existing))
+		for _, record := range existing {
+			archivedURLs[record.URL] = struct{}{}
+		}
+
+		buf := bytes.NewBuffer(data)
+		appended := 0
+		for _, record := range records {
+			if _, exists := archivedURLs[record.URL]; exists {
+				continue
+			}
+			line, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to marshal archive record: %w", err)
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+			archivedURLs[record.URL] = struct{}{}
+			appended++
+		}
+
+		if appended == 0 {
+			return nil
+		}
+
+		if _, err := store.PutIf(ctx, key, buf.Bytes(), version); err == nil {
+			return nil
+		} else if !errors.Is(err, ErrPreconditionFailed) {
+			return fmt.Errorf("failed to save archive '%s': %w", key, err)
+		}
+
+		log.Printf("Archive '%s' was modified concurrently, retrying append (attempt %d/%d)", key, attempt, SaveMaxAttempts)
+		time.Sleep(time.Duration(attempt) * SaveRetryDelay)
+	}
+
+	return fmt.Errorf("failed to append archive '%s' after %d attempts: %w", key, SaveMaxAttempts, ErrPreconditionFailed)
+}
+
+func parseArchiveRecords(data []byte) ([]ArchiveRecord, error) {
+	var records []ArchiveRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record ArchiveRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal archive record: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive records: %w", err)
+	}
+
+	return records, nil
+}
+
+// loadArchiveRecords は指定日（JST）のアーカイブを読み込む。アーカイブがない場合はErrStateNotFoundを返す。
+func loadArchiveRecords(ctx context.Context, store StateStore, date time.Time) ([]ArchiveRecord, error) {
+	key := archiveKey(date)
+	data, _, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := parseArchiveRecords(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse archive '%s': %w", key, err)
+	}
+
+	return records, nil
+}
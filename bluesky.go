@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	DefaultBlueskyHost = "https://bsky.social"
+	// BlueskyMaxGraphemes は投稿本文の上限（書記素クラスタ数）
+	BlueskyMaxGraphemes = 300
+	// blueskyMinParagraph は切り詰めた段落に残す最小の書記素数。これより短くなる段落は削除する。
+	blueskyMinParagraph = 10
+)
+
+var (
+	blueskyLinkPattern    = regexp.MustCompile(`https?://[^\s]+`)
+	blueskyHashtagPattern = regexp.MustCompile(`(?:^|\s)(#[^\s#]+)`)
+
+	// ErrBlueskyExpiredToken はアクセストークンの期限切れ。セッションを作り直して再試行する。
+	ErrBlueskyExpiredToken = errors.New("bluesky access token expired")
+)
+
+type BlueskyConfig struct {
+	Host        string `json:"host"`
+	Identifier  string `json:"identifier"`
+	AppPassword string `json:"app_password"`
+}
+
+type blueskySession struct {
+	AccessJwt string `json:"accessJwt"`
+	DID       string `json:"did"`
+}
+
+// createSessionの回数制限にかからないよう、セッションはホストとアカウントごとに使い回す
+var (
+	blueskySessionsMu sync.Mutex
+	blueskySessions   = make(map[string]*blueskySession)
+)
+
+type blueskyPost struct {
+	Type      string         `json:"$type"`
+	Text      string         `json:"text"`
+	CreatedAt string         `json:"createdAt"`
+	Langs     []string       `json:"langs"`
+	Facets    []blueskyFacet `json:"facets,omitempty"`
+	Embed     *blueskyEmbed  `json:"embed,omitempty"`
+}
+
+type blueskyFacet struct {
+	Index    blueskyByteSlice `json:"index"`
+	Features []blueskyFeature `json:"features"`
+}
+
+// blueskyByteSlice はUTF-8のバイト位置による本文の範囲
+type blueskyByteSlice struct {
+	ByteStart int `json:"byteStart"`
+	ByteEnd   int `json:"byteEnd"`
+}
+
+type blueskyFeature struct {
+	Type string `json:"$type"`
+	URI  string `json:"uri,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+type blueskyEmbed struct {
+	Type     string          `json:"$type"`
+	External blueskyExternal `json:"external"`
+}
+
+type blueskyExternal struct {
+	URI         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type blueskyPublisher struct {
+	host        string
+	identifier  string
+	appPassword string
+	httpClient  *http.Client
+}
+
+func newBlueskyPublisher(config *Config) *blueskyPublisher {
+	return &blueskyPublisher{
+		host:        strings.TrimSuffix(config.Bluesky.Host, "/"),
+		identifier:  config.Bluesky.Identifier,
+		appPassword: config.Bluesky.AppPassword,
+		httpClient:  &http.Client{Timeout: HTTPTimeout},
+	}
+}
+
+func (p *blueskyPublisher) Name() string {
+	return "bluesky"
+}
+
+// Publish は記事をリンクカード付きで投稿し、投稿のAT URIを返す。集計は投稿しない。
+func (p *blueskyPublisher) Publish(ctx context.Context, post *Publication) (string, error) {
+	if post.Article == nil {
+		return "", ErrUnsupportedPublication
+	}
+
+	record := newBlueskyPost(post.Text, post.Article, time.Now())
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would post to Bluesky (%d facets):\n%s", len(record.Facets), record.Text)
+		return "dry-run", nil
+	}
+
+	uri, err := p.createRecord(ctx, record)
+	if errors.Is(err, ErrBlueskyExpiredToken) {
+		p.forgetSession()
+		uri, err = p.createRecord(ctx, record)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to post to Bluesky: %w", err)
+	}
+	log.Printf("Posted to Bluesky: %s", uri)
+	return uri, nil
+}
+
+// Pin は何もしない。Blueskyには集計を投稿しないため固定する投稿がない。
+func (p *blueskyPublisher) Pin(ctx context.Context, id string) error {
+	return nil
+}
+
+// newBlueskyPost は本文を上限に収め、リンクとハッシュタグのfacetと記事のリンクカードを付けた投稿を作る
+func newBlueskyPost(text string, article *PostedURL, now time.Time) *blueskyPost {
+	text = fitBlueskyText(text)
+	return &blueskyPost{
+		Type:      "app.bsky.feed.post",
+		Text:      text,
+		CreatedAt: now.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Langs:     []string{"ja"},
+		Facets:    blueskyFacets(text),
+		Embed: &blueskyEmbed{
+			Type: "app.bsky.embed.external",
+			External: blueskyExternal{
+				URI:         article.URL,
+				Title:       article.Title,
+				Description: articleDescription(*article),
+			},
+		},
+	}
+}
+
+// fitBlueskyText は本文を書記素BlueskyMaxGraphemes個以内に収める。
+// リンクやハッシュタグを含む段落は途中で切らずに残し、それ以外の段落を長いものから末尾を「…」にして切り詰める（短くなりすぎる段落は削除する）。
+// それでも収まらない場合は、ハッシュタグの行、リンクを含む段落の順に後ろから段落ごと削除する。記事はリンクカードでも示される。
+func fitBlueskyText(text string) string {
+	paragraphs := strings.Split(text, "\n\n")
+	for {
+		excess := len(splitGraphemes(strings.Join(paragraphs, "\n\n"))) - BlueskyMaxGraphemes
+		if excess <= 0 {
+			return strings.Join(paragraphs, "\n\n")
+		}
+
+		longest := -1
+		longestLength := 0
+		for i, paragraph := range paragraphs {
+			if isProtectedParagraph(paragraph) {
+				continue
+			}
+			if length := len(splitGraphemes(paragraph)); length > longestLength {
+				longest, longestLength = i, length
+			}
+		}
+		if longest < 0 {
+			drop := len(paragraphs) - 1
+			for i := len(paragraphs) - 1; i >= 0; i-- {
+				if isHashtagLine(paragraphs[i]) {
+					drop = i
+					break
+				}
+			}
+			paragraphs = append(paragraphs[:drop], paragraphs[drop+1:]...)
+			continue
+		}
+
+		keep := longestLength - excess - 1
+		if keep < blueskyMinParagraph {
+			paragraphs = append(paragraphs[:longest], paragraphs[longest+1:]...)
+			continue
+		}
+		paragraphs[longest] = strings.TrimSpace(strings.Join(splitGraphemes(paragraphs[longest])[:keep], "")) + "…"
+	}
+}
+
+// isProtectedParagraph は途中で切るとリンクやハッシュタグが壊れる段落かを返す
+func isProtectedParagraph(paragraph string) bool {
+	return blueskyLinkPattern.MatchString(paragraph) || blueskyHashtagPattern.MatchString(paragraph)
+}
+
+func isHashtagLine(paragraph string) bool {
+	fields := strings.Fields(paragraph)
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "#") {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+// blueskyFacets は本文中のURLとハッシュタグの位置をfacetとして返す
+func blueskyFacets(text string) []blueskyFacet {
+	var facets []blueskyFacet
+	for _, match := range blueskyLinkPattern.FindAllStringIndex(text, -1) {
+		facets = append(facets, blueskyFacet{
+			Index:    blueskyByteSlice{ByteStart: match[0], ByteEnd: match[1]},
+			Features: []blueskyFeature{{Type: "app.bsky.richtext.facet#link", URI: text[match[0]:match[1]]}},
+		})
+	}
+	for _, match := range blueskyHashtagPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2], match[3]
+		facets = append(facets, blueskyFacet{
+			Index:    blueskyByteSlice{ByteStart: start, ByteEnd: end},
+			Features: []blueskyFeature{{Type: "app.bsky.richtext.facet#tag", Tag: text[start+1 : end]}},
+		})
+	}
+	return facets
+}
+
+// splitGraphemes は文字列を書記素クラスタに分ける。結合文字・異体字セレクタ・絵文字の修飾子とZWJ結合・国旗を1文字として扱う。
+func splitGraphemes(text string) []string {
+	var graphemes []string
+	var current []rune
+	regionalIndicators := 0
+	for _, r := range text {
+		extend := len(current) > 0 && (isGraphemeExtend(r) || current[len(current)-1] == '\u200d' ||
+			(current[len(current)-1] == '\r' && r == '\n') ||
+			(isRegionalIndicator(r) && regionalIndicators%2 == 1))
+		if !extend && len(current) > 0 {
+			graphemes = append(graphemes, string(current))
+			current = current[:0]
+			regionalIndicators = 0
+		}
+		if isRegionalIndicator(r) {
+			regionalIndicators++
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		graphemes = append(graphemes, string(current))
+	}
+	return graphemes
+}
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		r == '\u200d' ||
+		(r >= 0xfe00 && r <= 0xfe0f) ||
+		(r >= 0xe0020 && r <= 0xe007f) ||
+		(r >= 0xe0100 && r <= 0xe01ef) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+func (p *blueskyPublisher) sessionKey() string {
+	return p.host + " " + p.identifier
+}
+
+func (p *blueskyPublisher) forgetSession() {
+	blueskySessionsMu.Lock()
+	defer blueskySessionsMu.Unlock()
+	delete(blueskySessions, p.sessionKey())
+}
+
+func (p *blueskyPublisher) session(ctx context.Context) (*blueskySession, error) {
+	blueskySessionsMu.Lock()
+	defer blueskySessionsMu.Unlock()
+	if session, ok := blueskySessions[p.sessionKey()]; ok {
+		return session, nil
+	}
+
+	var session blueskySession
+	params := map[string]string{"identifier": p.identifier, "password": p.appPassword}
+	if err := p.xrpc(ctx, "com.atproto.server.createSession", "", params, &session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	blueskySessions[p.sessionKey()] = &session
+	return &session, nil
+}
+
+func (p *blueskyPublisher) createRecord(ctx context.Context, record *blueskyPost) (string, error) {
+	session, err := p.session(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		URI string `json:"uri"`
+	}
+	params := map[string]any{"repo": session.DID, "collection": record.Type, "record": record}
+	if err := p.xrpc(ctx, "com.atproto.repo.createRecord", session.AccessJwt, params, &result); err != nil {
+		return "", err
+	}
+	return result.URI, nil
+}
+
+// xrpc はXRPCのprocedureを呼び出し、応答をresultに読み込む
+func (p *blueskyPublisher) xrpc(ctx context.Context, method, accessToken string, params any, result any) error {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.host+"/xrpc/"+method, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var xrpcError struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &xrpcError) == nil && xrpcError.Error != "" {
+			if xrpcError.Error == "ExpiredToken" {
+				return ErrBlueskyExpiredToken
+			}
+			return fmt.Errorf("%s returned status code %d: %s (%s)", method, resp.StatusCode, xrpcError.Message, xrpcError.Error)
+		}
+		return fmt.Errorf("%s returned status code: %d", method, resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", method, err)
+	}
+	return nil
+}
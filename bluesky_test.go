@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// fakeBlueskyServer はcreateSessionとcreateRecordだけを扱うPDS。expireFirstを指定すると最初のトークンを期限切れとして扱う。
+type fakeBlueskyServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	expireFirst bool
+	sessions    int
+	records     []map[string]any
+	authHeaders []string
+}
+
+func newFakeBlueskyServer(t *testing.T, expireFirst bool) *fakeBlueskyServer {
+	t.Helper()
+	s := &fakeBlueskyServer{expireFirst: expireFirst}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeBlueskyServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var params map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.createSession":
+		if params["identifier"] != "kuma.example.com" || params["password"] != "app-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"AuthenticationRequired","message":"Invalid identifier or password"}`))
+			return
+		}
+		s.sessions++
+		json.NewEncoder(w).Encode(map[string]string{
+			"accessJwt": "jwt-" + string(rune('0'+s.sessions)),
+			"did":       "did:plc:kuma",
+		})
+	case "/xrpc/com.atproto.repo.createRecord":
+		auth := r.Header.Get("Authorization")
+		s.authHeaders = append(s.authHeaders, auth)
+		if s.expireFirst && auth == "Bearer jwt-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"ExpiredToken","message":"Token has expired"}`))
+			return
+		}
+		if params["repo"] != "did:plc:kuma" || params["collection"] != "app.bsky.feed.post" {
+			http.Error(w, "unexpected repo or collection", http.StatusBadRequest)
+			return
+		}
+		record, _ := params["record"].(map[string]any)
+		s.records = append(s.records, record)
+		json.NewEncoder(w).Encode(map[string]string{
+			"uri": "at://did:plc:kuma/app.bsky.feed.post/" + string(rune('a'+len(s.records)-1)),
+			"cid": "cid",
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBlueskyPublisher(server *fakeBlueskyServer) *blueskyPublisher {
+	return newBlueskyPublisher(&Config{Bluesky: BlueskyConfig{
+		Host:        server.URL,
+		Identifier:  "kuma.example.com",
+		AppPassword: "app-password",
+	}})
+}
+
+func testPublication() *Publication {
+	article := &PostedURL{
+		URL:         "https://example.com/news/1?id=2",
+		Title:       "住宅街でクマ目撃",
+		Description: "\n\n🔗 岩手県盛岡市の住宅街でクマが目撃されました…",
+	}
+	return &Publication{
+		Text:    "🐻 住宅街でクマ目撃\n\n🔗 https://example.com/news/1?id=2\n\n📍 岩手県\n\n#クマ出没情報",
+		Article: article,
+	}
+}
+
+func TestBlueskyPublishReusesSession(t *testing.T) {
+	server := newFakeBlueskyServer(t, false)
+	publisher := newTestBlueskyPublisher(server)
+	defer publisher.forgetSession()
+
+	for i, want := range []string{"at://did:plc:kuma/app.bsky.feed.post/a", "at://did:plc:kuma/app.bsky.feed.post/b"} {
+		uri, err := publisher.Publish(context.Background(), testPublication())
+		if err != nil {
+			t.Fatalf("Publish #%d: %v", i+1, err)
+		}
+		if uri != want {
+			t.Errorf("Publish #%d = %s, want %s", i+1, uri, want)
+		}
+	}
+
+	if server.sessions != 1 {
+		t.Errorf("createSession called %d times, want 1", server.sessions)
+	}
+	for i, auth := range server.authHeaders {
+		if auth != "Bearer jwt-1" {
+			t.Errorf("createRecord #%d Authorization = %q, want %q", i+1, auth, "Bearer jwt-1")
+		}
+	}
+}
+
+func TestBlueskyPublishRetriesExpiredToken(t *testing.T) {
+	server := newFakeBlueskyServer(t, true)
+	publisher := newTestBlueskyPublisher(server)
+	defer publisher.forgetSession()
+
+	uri, err := publisher.Publish(context.Background(), testPublication())
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if uri != "at://did:plc:kuma/app.bsky.feed.post/a" {
+		t.Errorf("Publish = %s", uri)
+	}
+	if server.sessions != 2 {
+		t.Errorf("createSession called %d times, want 2", server.sessions)
+	}
+	if want := []string{"Bearer jwt-1", "Bearer jwt-2"}; strings.Join(server.authHeaders, ",") != strings.Join(want, ",") {
+		t.Errorf("createRecord Authorization = %v, want %v", server.authHeaders, want)
+	}
+}
+
+func TestBlueskyPublishSkipsSummaries(t *testing.T) {
+	publisher := newBlueskyPublisher(&Config{})
+	if _, err := publisher.Publish(context.Background(), &Publication{Text: "集計"}); err != ErrUnsupportedPublication {
+		t.Errorf("Publish without article = %v, want ErrUnsupportedPublication", err)
+	}
+}
+
+func TestBlueskyRecordPayload(t *testing.T) {
+	server := newFakeBlueskyServer(t, false)
+	publisher := newTestBlueskyPublisher(server)
+	defer publisher.forgetSession()
+
+	post := testPublication()
+	if _, err := publisher.Publish(context.Background(), post); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(server.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(server.records))
+	}
+	record := server.records[0]
+
+	text, _ := record["text"].(string)
+	if text != post.Text {
+		t.Errorf("text = %q, want %q", text, post.Text)
+	}
+	if record["$type"] != "app.bsky.feed.post" {
+		t.Errorf("$type = %v", record["$type"])
+	}
+
+	var facets []blueskyFacet
+	data, _ := json.Marshal(record["facets"])
+	if err := json.Unmarshal(data, &facets); err != nil {
+		t.Fatalf("failed to decode facets: %v", err)
+	}
+	link := "https://example.com/news/1?id=2"
+	linkStart := strings.Index(text, link)
+	tagStart := strings.Index(text, "#クマ出没情報")
+	want := []blueskyFacet{
+		{
+			Index:    blueskyByteSlice{ByteStart: linkStart, ByteEnd: linkStart + len(link)},
+			Features: []blueskyFeature{{Type: "app.bsky.richtext.facet#link", URI: link}},
+		},
+		{
+			Index:    blueskyByteSlice{ByteStart: tagStart, ByteEnd: tagStart + len("#クマ出没情報")},
+			Features: []blueskyFeature{{Type: "app.bsky.richtext.facet#tag", Tag: "クマ出没情報"}},
+		},
+	}
+	if len(facets) != len(want) {
+		t.Fatalf("facets = %+v, want %+v", facets, want)
+	}
+	for i := range want {
+		if facets[i].Index != want[i].Index || len(facets[i].Features) != 1 || facets[i].Features[0] != want[i].Features[0] {
+			t.Errorf("facet %d = %+v, want %+v", i, facets[i], want[i])
+		}
+		// byteStart / byteEndはUTF-8のバイト位置なので、本文を切り出すとリンクやタグそのものになる
+		if got := text[facets[i].Index.ByteStart:facets[i].Index.ByteEnd]; got != link && got != "#クマ出没情報" {
+			t.Errorf("facet %d covers %q", i, got)
+		}
+	}
+
+	var embed blueskyEmbed
+	data, _ = json.Marshal(record["embed"])
+	if err := json.Unmarshal(data, &embed); err != nil {
+		t.Fatalf("failed to decode embed: %v", err)
+	}
+	wantEmbed := blueskyEmbed{
+		Type: "app.bsky.embed.external",
+		External: blueskyExternal{
+			URI:         post.Article.URL,
+			Title:       post.Article.Title,
+			Description: "岩手県盛岡市の住宅街でクマが目撃されました…",
+		},
+	}
+	if embed != wantEmbed {
+		t.Errorf("embed = %+v, want %+v", embed, wantEmbed)
+	}
+}
+
+func TestSplitGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "ascii", text: "abc", want: []string{"a", "b", "c"}},
+		{name: "japanese", text: "クマ", want: []string{"ク", "マ"}},
+		{name: "combining voiced mark", text: "か\u3099き", want: []string{"か\u3099", "き"}},
+		{name: "zwj family", text: "\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466!", want: []string{"\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466", "!"}},
+		{name: "skin tone", text: "\U0001f44d\U0001f3fd\U0001f44d", want: []string{"\U0001f44d\U0001f3fd", "\U0001f44d"}},
+		{name: "variation selector", text: "\u2764\ufe0f", want: []string{"\u2764\ufe0f"}},
+		{name: "flags", text: "🇯🇵🇺🇸🇯", want: []string{"🇯🇵", "🇺🇸", "🇯"}},
+		{name: "tag sequence", text: "🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f", want: []string{"🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f"}},
+		{name: "crlf", text: "a\r\nb", want: []string{"a", "\r\n", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitGraphemes(tt.text)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("splitGraphemes(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitBlueskyText(t *testing.T) {
+	family := "\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466"
+	link := "https://example.com/" + strings.Repeat("a", 100)
+	longLink := "https://example.com/" + strings.Repeat("b", 200)
+	veryLongLink := "https://example.com/" + strings.Repeat("c", 260)
+
+	tests := []struct {
+		name string
+		text string
+		// want が空でなければ結果と一致すること
+		want string
+		// keep は結果にそのまま含まれるべき段落
+		keep []string
+		// drop は結果に含まれてはならない段落
+		drop []string
+	}{
+		{
+			name: "short text unchanged",
+			text: "🐻 クマ目撃\n\n🔗 https://example.com/\n\n#クマ出没情報",
+			want: "🐻 クマ目撃\n\n🔗 https://example.com/\n\n#クマ出没情報",
+		},
+		{
+			name: "exactly the limit with zwj emoji",
+			text: strings.Repeat("あ", BlueskyMaxGraphemes-1) + family,
+			want: strings.Repeat("あ", BlueskyMaxGraphemes-1) + family,
+		},
+		{
+			name: "one grapheme over the limit with zwj emoji",
+			text: strings.Repeat("あ", BlueskyMaxGraphemes) + family,
+			want: strings.Repeat("あ", BlueskyMaxGraphemes-1) + "…",
+		},
+		{
+			name: "flags count as one grapheme each",
+			text: strings.Repeat("🇯🇵", BlueskyMaxGraphemes),
+			want: strings.Repeat("🇯🇵", BlueskyMaxGraphemes),
+		},
+		{
+			name: "truncates the longest unprotected paragraph",
+			text: "🐻 クマ目撃\n\n" + strings.Repeat("い", 400) + "\n\n🔗 " + link + "\n\n#クマ出没情報",
+			keep: []string{"🐻 クマ目撃", "🔗 " + link, "#クマ出没情報"},
+		},
+		{
+			name: "drops paragraphs that would become too short",
+			text: strings.Repeat("う", 15) + "\n\n🔗 " + veryLongLink + "\n\n#クマ出没情報",
+			want: "🔗 " + veryLongLink + "\n\n#クマ出没情報",
+		},
+		{
+			name: "drops whole protected paragraphs instead of cutting them",
+			text: "🔗 " + longLink + "\n\n📍 岩手県 #岩手県\n\n🔗 " + link + "\n\n#クマ出没情報 #クマ",
+			keep: []string{"🔗 " + longLink},
+			drop: []string{"#クマ出没情報 #クマ", "🔗 " + link},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitBlueskyText(tt.text)
+			if n := len(splitGraphemes(got)); n > BlueskyMaxGraphemes {
+				t.Fatalf("fitBlueskyText returned %d graphemes", n)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("fitBlueskyText returned invalid UTF-8: %q", got)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("fitBlueskyText = %q, want %q", got, tt.want)
+			}
+
+			paragraphs := strings.Split(got, "\n\n")
+			for _, paragraph := range tt.keep {
+				if !slices.Contains(paragraphs, paragraph) {
+					t.Errorf("paragraph %q missing from %q", paragraph, got)
+				}
+			}
+			for _, paragraph := range tt.drop {
+				if slices.Contains(paragraphs, paragraph) {
+					t.Errorf("paragraph %q should have been dropped from %q", paragraph, got)
+				}
+			}
+			// 残ったリンクとハッシュタグは元の本文のものがそのまま残っている
+			for _, match := range blueskyLinkPattern.FindAllString(got, -1) {
+				if !strings.Contains(tt.text, match+"\n") && !strings.HasSuffix(tt.text, match) {
+					t.Errorf("link %q was cut", match)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const CanonicalFetchTimeout = 5 * time.Second
+
+var (
+	defaultTrackingParams = []string{
+		"fbclid", "gclid", "dclid", "yclid", "msclkid", "igshid",
+		"mc_cid", "mc_eid", "_ga", "_gl", "cmpid", "ncid", "source",
+	}
+	trackingParamPrefixes = []string{"utm_"}
+	ampParams             = []string{"amp", "outputType", "usqp"}
+	mobileHostPrefixes    = []string{"amp.", "m.", "sp."}
+)
+
+// urlCanonicalizer は同じ記事の異なるURL（トラッキングパラメータ・AMP・モバイル版・http/https）を同じキーに正規化する
+type urlCanonicalizer struct {
+	trackingParams   map[string]struct{}
+	resolveCanonical bool
+}
+
+func newURLCanonicalizer(rssConfig *RSSConfig) *urlCanonicalizer {
+	trackingParams := make(map[string]struct{})
+	for _, param := range defaultTrackingParams {
+		trackingParams[strings.ToLower(param)] = struct{}{}
+	}
+	for _, param := range rssConfig.TrackingParams {
+		trackingParams[strings.ToLower(param)] = struct{}{}
+	}
+
+	return &urlCanonicalizer{
+		trackingParams:   trackingParams,
+		resolveCanonical: rssConfig.ResolveCanonical,
+	}
+}
+
+func (c *urlCanonicalizer) canonicalize(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range mobileHostPrefixes {
+		if strings.HasPrefix(host, prefix) && strings.Count(host, ".") > 1 {
+			host = strings.TrimPrefix(host, prefix)
+			break
+		}
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if strings.HasPrefix(u.Path, "/amp/") {
+		u.Path = strings.TrimPrefix(u.Path, "/amp")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/amp/")
+	u.Path = strings.TrimSuffix(u.Path, "/amp")
+	u.Path = strings.Replace(u.Path, ".amp.html", ".html", 1)
+	u.RawPath = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for key := range query {
+		if c.isTrackingParam(key) {
+			query.Del(key)
+		}
+	}
+	for _, param := range ampParams {
+		query.Del(param)
+	}
+	u.RawQuery = encodeSortedQuery(query)
+
+	return u.String()
+}
+
+func (c *urlCanonicalizer) isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	if _, ok := c.trackingParams[key]; ok {
+		return true
+	}
+	for _, prefix := range trackingParamPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *urlCanonicalizer) dedupeKey(posted PostedURL) string {
+	if posted.CanonicalURL != "" {
+		return posted.CanonicalURL
+	}
+	return c.canonicalize(posted.URL)
+}
+
+// resolveCanonicalLink は記事ページの<link rel="canonical">を取得する。取得できない場合は空文字を返す。
+func resolveCanonicalLink(ctx context.Context, articleURL string) string {
+	ctx, cancel := context.WithTimeout(ctx, CanonicalFetchTimeout)
+	defer cancel()
+
+	body, err := fetchURL(ctx, articleURL, nil)
+	if err != nil {
+		log.Printf("Failed to fetch %s for canonical link: %v", articleURL, err)
+		return ""
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	href, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href")
+	if !ok {
+		return ""
+	}
+
+	base, err := url.Parse(articleURL)
+	if err != nil {
+		return ""
+	}
+	canonical, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+
+	return canonical.String()
+}
+
+func encodeSortedQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
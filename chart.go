@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	ChartWidth          = 800
+	ChartRankingLimit   = 15
+	ChartTrendDays      = 30
+	chartMargin         = 24
+	chartTitleHeight    = 48
+	chartRowHeight      = 28
+	chartLabelWidth     = 110
+	chartTrendHeight    = 400
+	chartFontSize       = 14
+	chartTitleFontSize  = 18
+	MaxMediaDescription = 1500
+)
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartText       = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	chartGrid       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	chartBar        = color.RGBA{0x8b, 0x5a, 0x2b, 0xff}
+	chartOtherBar   = color.RGBA{0xaa, 0xaa, 0xaa, 0xff}
+)
+
+// 埋め込みのGoフォントは日本語を含まないため、グラフ上の都道府県名はローマ字で表記する（代替テキストは日本語）
+var prefectureRomaji = map[string]string{
+	"北海道": "Hokkaido", "青森県": "Aomori", "岩手県": "Iwate", "宮城県": "Miyagi", "秋田県": "Akita",
+	"山形県": "Yamagata", "福島県": "Fukushima", "茨城県": "Ibaraki", "栃木県": "Tochigi", "群馬県": "Gunma",
+	"埼玉県": "Saitama", "千葉県": "Chiba", "東京都": "Tokyo", "神奈川県": "Kanagawa", "新潟県": "Niigata",
+	"富山県": "Toyama", "石川県": "Ishikawa", "福井県": "Fukui", "山梨県": "Yamanashi", "長野県": "Nagano",
+	"岐阜県": "Gifu", "静岡県": "Shizuoka", "愛知県": "Aichi", "三重県": "Mie", "滋賀県": "Shiga",
+	"京都府": "Kyoto", "大阪府": "Osaka", "兵庫県": "Hyogo", "奈良県": "Nara", "和歌山県": "Wakayama",
+	"鳥取県": "Tottori", "島根県": "Shimane", "岡山県": "Okayama", "広島県": "Hiroshima", "山口県": "Yamaguchi",
+	"徳島県": "Tokushima", "香川県": "Kagawa", "愛媛県": "Ehime", "高知県": "Kochi", "福岡県": "Fukuoka",
+	"佐賀県": "Saga", "長崎県": "Nagasaki", "熊本県": "Kumamoto", "大分県": "Oita", "宮崎県": "Miyazaki",
+	"鹿児島県": "Kagoshima", "沖縄県": "Okinawa", OtherPrefecture: "Other",
+}
+
+// DailyCount は日別の件数（推移グラフ用）
+type DailyCount struct {
+	Date  time.Time
+	Count int
+}
+
+// chartAttachment はアップロードする画像と代替テキスト
+type chartAttachment struct {
+	Name        string
+	PNG         []byte
+	Description string
+}
+
+type chartFonts struct {
+	regular font.Face
+	bold    font.Face
+}
+
+var (
+	loadedChartFonts *chartFonts
+	chartFontsErr    error
+	chartFontsOnce   sync.Once
+)
+
+func loadChartFonts() (*chartFonts, error) {
+	chartFontsOnce.Do(func() {
+		regular, err := newFontFace(goregular.TTF, chartFontSize)
+		if err != nil {
+			chartFontsErr = err
+			return
+		}
+		bold, err := newFontFace(gobold.TTF, chartTitleFontSize)
+		if err != nil {
+			chartFontsErr = err
+			return
+		}
+		loadedChartFonts = &chartFonts{regular: regular, bold: bold}
+	})
+	return loadedChartFonts, chartFontsErr
+}
+
+func newFontFace(ttf []byte, size float64) (font.Face, error) {
+	parsed, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chart font: %w", err)
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chart font face: %w", err)
+	}
+	return face, nil
+}
+
+func newChartCanvas(height int) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, ChartWidth, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+	return canvas
+}
+
+func fillRect(canvas *image.RGBA, rect image.Rectangle, c color.Color) {
+	draw.Draw(canvas, rect, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+func drawText(canvas *image.RGBA, face font.Face, x, y int, text string) {
+	drawer := &font.Drawer{Dst: canvas, Src: image.NewUniform(chartText), Face: face, Dot: fixed.P(x, y)}
+	drawer.DrawString(text)
+}
+
+func drawTextRight(canvas *image.RGBA, face font.Face, right, y int, text string) {
+	width := font.MeasureString(face, text).Ceil()
+	drawText(canvas, face, right-width, y, text)
+}
+
+// drawLine はBresenhamのアルゴリズムで太さthicknessの線を描く
+func drawLine(canvas *image.RGBA, x0, y0, x1, y1, thickness int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	half := thickness / 2
+	for e := dx + dy; ; {
+		fillRect(canvas, image.Rect(x0-half, y0-half, x0-half+thickness, y0-half+thickness), c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else if e2 <= dx {
+			e += dx
+			y0 += sy
+		} else {
+			e += dy + dx
+			x0 += sx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func encodePNG(canvas image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, fmt.Errorf("failed to encode chart PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderRankingChart は都道府県別ランキングの横棒グラフを描く。上位ChartRankingLimit件と「その他」を表示する。
+func renderRankingChart(title string, stats []PrefectureCount) ([]byte, error) {
+	fonts, err := loadChartFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := chartRows(stats)
+	height := chartTitleHeight + max(len(rows), 1)*chartRowHeight + chartMargin
+	canvas := newChartCanvas(height)
+	drawText(canvas, fonts.bold, chartMargin, chartMargin+chartTitleFontSize/2, title)
+
+	maxCount := 1
+	for _, stat := range rows {
+		maxCount = max(maxCount, stat.Count)
+	}
+
+	barLeft := chartMargin + chartLabelWidth
+	barMaxWidth := ChartWidth - barLeft - chartMargin - 50
+	for i, stat := range rows {
+		top := chartTitleHeight + i*chartRowHeight
+		baseline := top + chartRowHeight/2 + chartFontSize/2 - 2
+		drawTextRight(canvas, fonts.regular, barLeft-8, baseline, prefectureRomaji[stat.Prefecture])
+
+		barColor := chartBar
+		if stat.Prefecture == OtherPrefecture {
+			barColor = chartOtherBar
+		}
+		width := max(stat.Count*barMaxWidth/maxCount, 2)
+		fillRect(canvas, image.Rect(barLeft, top+4, barLeft+width, top+chartRowHeight-4), barColor)
+		drawText(canvas, fonts.regular, barLeft+width+6, baseline, fmt.Sprintf("%d", stat.Count))
+	}
+	if len(rows) == 0 {
+		drawText(canvas, fonts.regular, barLeft, chartTitleHeight+chartRowHeight/2+chartFontSize/2, "No sightings")
+	}
+
+	return encodePNG(canvas)
+}
+
+// chartRows はグラフに表示する行（上位と「その他」）を返す
+func chartRows(stats []PrefectureCount) []PrefectureCount {
+	var rows []PrefectureCount
+	var other *PrefectureCount
+	for i, stat := range stats {
+		if stat.Prefecture == OtherPrefecture {
+			other = &stats[i]
+			continue
+		}
+		if len(rows) < ChartRankingLimit {
+			rows = append(rows, stat)
+		}
+	}
+	if other != nil {
+		rows = append(rows, *other)
+	}
+	return rows
+}
+
+// renderTrendChart は日別件数の折れ線グラフを描く
+func renderTrendChart(title string, days []DailyCount) ([]byte, error) {
+	fonts, err := loadChartFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := newChartCanvas(chartTrendHeight)
+	drawText(canvas, fonts.bold, chartMargin, chartMargin+chartTitleFontSize/2, title)
+
+	maxCount := 1
+	for _, day := range days {
+		maxCount = max(maxCount, day.Count)
+	}
+	step := max((maxCount+3)/4, 1)
+	axisMax := step * 4
+
+	left := chartMargin + 40
+	right := ChartWidth - chartMargin - 12
+	top := chartTitleHeight + 8
+	bottom := chartTrendHeight - chartMargin - 20
+	for value := 0; value <= axisMax; value += step {
+		y := bottom - value*(bottom-top)/axisMax
+		fillRect(canvas, image.Rect(left, y, right, y+1), chartGrid)
+		drawTextRight(canvas, fonts.regular, left-6, y+chartFontSize/2-2, fmt.Sprintf("%d", value))
+	}
+
+	if len(days) == 0 {
+		return encodePNG(canvas)
+	}
+
+	pointX := func(i int) int {
+		if len(days) == 1 {
+			return (left + right) / 2
+		}
+		return left + i*(right-left)/(len(days)-1)
+	}
+	pointY := func(count int) int {
+		return bottom - count*(bottom-top)/axisMax
+	}
+
+	for i, day := range days {
+		// 週ごとと最終日に目盛りを付ける。最終日の直前の目盛りはラベルが重なるので省く
+		if (i%7 == 0 && len(days)-1-i >= 3) || i == len(days)-1 {
+			x := pointX(i)
+			fillRect(canvas, image.Rect(x, top, x+1, bottom), chartGrid)
+			label := day.Date.Format("1/2")
+			width := font.MeasureString(fonts.regular, label).Ceil()
+			drawText(canvas, fonts.regular, x-width/2, bottom+chartFontSize+4, label)
+		}
+	}
+	for i := 1; i < len(days); i++ {
+		drawLine(canvas, pointX(i-1), pointY(days[i-1].Count), pointX(i), pointY(days[i].Count), 3, chartBar)
+	}
+	for i, day := range days {
+		x, y := pointX(i), pointY(day.Count)
+		fillRect(canvas, image.Rect(x-3, y-3, x+4, y+4), chartBar)
+	}
+
+	return encodePNG(canvas)
+}
+
+// rankingChartDescription はランキングの棒グラフの代替テキストを返す
+func rankingChartDescription(heading string, stats []PrefectureCount) string {
+	if len(stats) == 0 {
+		return truncateMediaDescription(heading + "の都道府県別ランキングの棒グラフ。出没情報はありません。")
+	}
+
+	var parts []string
+	for _, stat := range chartRows(stats) {
+		parts = append(parts, fmt.Sprintf("%s %d件", stat.Prefecture, stat.Count))
+	}
+	return truncateMediaDescription(fmt.Sprintf("%sの都道府県別ランキングの棒グラフ。%s。", heading, strings.Join(parts, "、")))
+}
+
+// trendChartDescription は日別推移の折れ線グラフの代替テキストを返す
+func trendChartDescription(days []DailyCount) string {
+	if len(days) == 0 {
+		return "日別件数の折れ線グラフ。データはありません。"
+	}
+
+	total := 0
+	peak := days[0]
+	var parts []string
+	for _, day := range days {
+		total += day.Count
+		if day.Count > peak.Count {
+			peak = day
+		}
+		parts = append(parts, fmt.Sprintf("%s %d件", day.Date.Format("1/2"), day.Count))
+	}
+
+	return truncateMediaDescription(fmt.Sprintf("%s〜%sの%d日間の日別件数の折れ線グラフ。合計%d件、最多は%sの%d件。日別: %s。",
+		days[0].Date.Format("1月2日"), days[len(days)-1].Date.Format("1月2日"), len(days), total,
+		peak.Date.Format("1月2日"), peak.Count, strings.Join(parts, "、")))
+}
+
+func truncateMediaDescription(description string) string {
+	return truncateRunes(description, MaxMediaDescription)
+}
+
+// truncateRunes はtextがmaxRunes文字を超える場合に末尾を「…」にして切り詰める
+func truncateRunes(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+// loadDailyCounts はendの前日までdays日分の日別件数をアーカイブから集計する
+func loadDailyCounts(ctx context.Context, store StateStore, end time.Time, days int) ([]DailyCount, error) {
+	counts := make([]DailyCount, 0, days)
+	for date := end.AddDate(0, 0, -days); date.Before(end); date = date.AddDate(0, 0, 1) {
+		records, err := loadArchiveRange(ctx, store, date, date.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		_, total := aggregateArchiveRecords(records)
+		counts = append(counts, DailyCount{Date: date, Count: total})
+	}
+	return counts, nil
+}
+
+// uploadCharts は画像をアップロードしてメディアIDを返す。失敗した画像は投稿に添付しない。
+func uploadCharts(ctx context.Context, client *mastodon.Client, charts []chartAttachment) []mastodon.ID {
+	var mediaIDs []mastodon.ID
+	for _, chart := range charts {
+		if isDryRun(ctx) {
+			log.Printf("DRY RUN: Would upload chart '%s' (%d bytes) with description:\n%s", chart.Name, len(chart.PNG), chart.Description)
+			continue
+		}
+
+		attachment, err := client.UploadMediaFromMedia(ctx, &mastodon.Media{
+			File:        bytes.NewReader(chart.PNG),
+			Description: chart.Description,
+		})
+		if err != nil {
+			log.Printf("Failed to upload chart '%s': %v", chart.Name, err)
+			continue
+		}
+		mediaIDs = append(mediaIDs, attachment.ID)
+	}
+	return mediaIDs
+}
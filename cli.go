@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const cliUsage = `Usage: kuma-bot [command] [options]
+
+Commands:
+  run [--sources a,b]               取得・投稿と投稿時刻を迎えた集計を実行（省略時と同じ）
+  summary [--date YYYY-MM-DD]       指定日（省略時は前日）の集計を投稿
+  backfill --from YYYY-MM-DD [--to YYYY-MM-DD]
+                                    期間のアーカイブをタイムラインから補完
+  post-url [--source rss] <url>     記事ページを取得してRSSニュースとして投稿
+  state list [--prefix p]           保存されている状態のキーを一覧
+  state prune                       保持期間を過ぎた投稿済みURL・検証子・稼働状況を削除
+  state export [--out file]         状態をJSONに書き出す（省略時は標準出力）
+  state import [--overwrite] <file> 書き出したJSONから状態を読み込む
+  sources test <name>               取得元から記事を取得して表示（投稿・状態の更新なし）
+  config validate                   設定ファイルとRSS設定を検証
+  serve                             常駐してスケジュールに従い取得と集計を実行（SIGTERMで終了）
+
+DRY_RUN=1 を指定すると投稿と状態の更新を行わずログのみ出力します。`
+
+// runCLI はローカル実行のサブコマンドを実行する。引数がない場合はrunと同じ。
+func runCLI(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		_, err := handleKumaBotRequest(ctx, KumaBotEvent{})
+		return err
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "run":
+		return runRunCommand(ctx, args)
+	case "summary":
+		return runSummaryCommand(ctx, args)
+	case "backfill":
+		return runBackfillCommand(ctx, args)
+	case "post-url":
+		return runPostURLCommand(ctx, args)
+	case "state":
+		return runStateCommand(ctx, args)
+	case "sources":
+		return runSourcesCommand(ctx, args)
+	case "config":
+		return runConfigCommand(ctx, args)
+	case "serve":
+		return runServe(ctx, args)
+	case "help", "-h", "--help":
+		fmt.Println(cliUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s'\n\n%s", command, cliUsage)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), cliUsage)
+	}
+	return flags
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runRunCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("run")
+	sources := flags.String("sources", "", "comma-separated source names")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	_, err := handleKumaBotRequest(ctx, KumaBotEvent{Mode: EventModeRun, Sources: splitList(*sources)})
+	return err
+}
+
+func runSummaryCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("summary")
+	date := flags.String("date", "", "summary date (YYYY-MM-DD, JST)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	_, err := handleKumaBotRequest(ctx, KumaBotEvent{Mode: EventModeSummary, Date: *date})
+	return err
+}
+
+func runBackfillCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("backfill")
+	from := flags.String("from", "", "first date (YYYY-MM-DD, JST)")
+	to := flags.String("to", "", "last date (YYYY-MM-DD, JST, defaults to --from)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("--from is required")
+	}
+	if *to == "" {
+		*to = *from
+	}
+
+	start, err := eventDate(KumaBotEvent{Date: *from})
+	if err != nil {
+		return err
+	}
+	end, err := eventDate(KumaBotEvent{Date: *to})
+	if err != nil {
+		return err
+	}
+	if end.Before(start) {
+		return fmt.Errorf("--to %s is before --from %s", *to, *from)
+	}
+
+	total := 0
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		result, err := handleKumaBotRequest(ctx, KumaBotEvent{Mode: EventModeBackfill, Date: date.Format(ArchiveDateFormat)})
+		if err != nil {
+			return err
+		}
+		total += result.Backfilled
+	}
+
+	fmt.Printf("Backfilled %d records from %s to %s\n", total, *from, *to)
+	return nil
+}
+
+// openCLIState はサブコマンドで使う設定と状態の保存先を開く
+func openCLIState(ctx context.Context) (*Config, StateStore, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := newStateStore(ctx, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create state store: %w", err)
+	}
+	return config, store, nil
+}
+
+// withRunLease は定期実行と同時に状態を書き換えないよう、リースを取得してからfnを実行する
+func withRunLease(ctx context.Context, store StateStore, fn func() error) error {
+	if isDryRun(ctx) {
+		return fn()
+	}
+
+	lease, err := acquireRunLease(ctx, store)
+	if err != nil {
+		return fmt.Errorf("failed to acquire run lease: %w", err)
+	}
+	defer releaseRunLease(ctx, store, lease)
+
+	return fn()
+}
+
+func runPostURLCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("post-url")
+	sourceName := flags.String("source", SourceRSS, "RSS source used to format the post")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("post-url requires exactly one URL")
+	}
+	articleURL := flags.Arg(0)
+	if parsed, err := url.Parse(articleURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid article URL '%s'", articleURL)
+	}
+
+	config, store, err := openCLIState(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return withRunLease(ctx, store, func() error {
+		rssConfig, err := loadRSSConfig(ctx, store, config)
+		if err != nil {
+			return fmt.Errorf("failed to load RSS config: %w", err)
+		}
+		if sourceConfig, ok := findSourceConfig(rssConfig, *sourceName); !ok {
+			return fmt.Errorf("unknown source '%s'", *sourceName)
+		} else if sourceConfig.Type != SourceRSS {
+			return fmt.Errorf("source '%s' is not an RSS source", *sourceName)
+		}
+
+		env := &sourceEnv{rssConfig: rssConfig, health: newHealthTracker(make(map[string]*SourceHealth))}
+		sources, err := buildSources(env)
+		if err != nil {
+			return fmt.Errorf("failed to build sources: %w", err)
+		}
+		sources, err = filterSources(sources, []string{*sourceName})
+		if err != nil {
+			return err
+		}
+
+		existingURLs, err := loadPostedURLs(ctx, store, config)
+		if err != nil {
+			return fmt.Errorf("failed to load posted URLs: %w", err)
+		}
+		canonicalizer := newURLCanonicalizer(rssConfig)
+		key := canonicalizer.canonicalize(articleURL)
+		for _, posted := range existingURLs {
+			if canonicalizer.dedupeKey(posted) == key || posted.AliasURL == key {
+				return fmt.Errorf("%s has already been posted", articleURL)
+			}
+		}
+
+		article, err := fetchArticlePage(ctx, articleURL)
+		if err != nil {
+			return err
+		}
+		article.Source = sources[0].Name()
+		article.CanonicalURL = key
+		locateArticle(&article)
+
+		client := newMastodonClient(config)
+		detector := newNearDuplicateDetector(NearDuplicateConfig{Mode: NearDuplicateModeOff}, nil)
+		failed, err := postToMastodon(ctx, store, config, client, []sourceBatch{{source: sources[0], articles: []PostedURL{article}}}, detector)
+		if err != nil {
+			return fmt.Errorf("failed to post to Mastodon: %w", err)
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to post %s", articleURL)
+		}
+		return nil
+	})
+}
+
+func findSourceConfig(rssConfig *RSSConfig, name string) (SourceConfig, bool) {
+	sourceConfigs := rssConfig.Sources
+	if len(sourceConfigs) == 0 {
+		sourceConfigs = defaultSourceConfigs
+	}
+	for _, sourceConfig := range sourceConfigs {
+		if sourceConfig.Name == name || (sourceConfig.Name == "" && sourceConfig.Type == name) {
+			return sourceConfig, true
+		}
+	}
+	return SourceConfig{}, false
+}
+
+// fetchArticlePage は記事ページのOGPとmetaタグからRSS記事と同じ形の記事を作る
+func fetchArticlePage(ctx context.Context, articleURL string) (PostedURL, error) {
+	doc, err := fetchDocument(ctx, articleURL, nil)
+	if err != nil {
+		return PostedURL{}, err
+	}
+
+	title := metaContent(doc, `meta[property="og:title"]`)
+	if title == "" {
+		title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	if title == "" {
+		return PostedURL{}, fmt.Errorf("no title found in %s", articleURL)
+	}
+
+	var description string
+	if text := metaContent(doc, `meta[property="og:description"]`, `meta[name="description"]`); text != "" {
+		description = "\n\n🔗 " + text + "…"
+	}
+
+	publishedAt := time.Now()
+	if published := metaContent(doc, `meta[property="article:published_time"]`); published != "" {
+		if parsed, err := time.Parse(time.RFC3339, published); err == nil {
+			publishedAt = parsed
+		}
+	}
+
+	article := PostedURL{
+		URL:         articleURL,
+		Title:       title,
+		Description: description,
+		PublishedAt: publishedAt,
+	}
+	if inferred := inferPrefectures(title + " " + description); len(inferred) > 0 {
+		article.Prefecture = inferred[0]
+		article.Prefectures = inferred
+	}
+	return article, nil
+}
+
+func metaContent(doc *goquery.Document, selectors ...string) string {
+	for _, selector := range selectors {
+		if content, ok := doc.Find(selector).First().Attr("content"); ok && strings.TrimSpace(content) != "" {
+			return strings.TrimSpace(content)
+		}
+	}
+	return ""
+}
+
+func runSourcesCommand(ctx context.Context, args []string) error {
+	if len(args) != 2 || args[0] != "test" {
+		return fmt.Errorf("usage: sources test <name>")
+	}
+	name := args[1]
+
+	config, store, err := openCLIState(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rssConfig, err := loadRSSConfig(ctx, store, config)
+	if err != nil {
+		return fmt.Errorf("failed to load RSS config: %w", err)
+	}
+
+	// 条件付きGETで結果が空にならないよう検証子を使わず、稼働状況も保存しない
+	health := newHealthTracker(make(map[string]*SourceHealth))
+	env := &sourceEnv{rssConfig: rssConfig, cache: &httpCache{validators: make(map[string]HTTPValidator)}, health: health}
+	sources, err := buildSources(env)
+	if err != nil {
+		return fmt.Errorf("failed to build sources: %w", err)
+	}
+	sources, err = filterSources(sources, []string{name})
+	if err != nil {
+		return err
+	}
+
+	existingURLs, err := loadPostedURLs(ctx, store, config)
+	if err != nil {
+		return fmt.Errorf("failed to load posted URLs: %w", err)
+	}
+	canonicalizer := newURLCanonicalizer(rssConfig)
+	postedKeys := make(map[string]struct{}, len(existingURLs))
+	for _, posted := range existingURLs {
+		postedKeys[canonicalizer.dedupeKey(posted)] = struct{}{}
+		if posted.AliasURL != "" {
+			postedKeys[posted.AliasURL] = struct{}{}
+		}
+	}
+
+	articles, err := sources[0].Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch articles from source '%s': %w", name, err)
+	}
+
+	newCount := 0
+	for _, article := range articles {
+		article.Source = name
+		locateArticle(&article)
+
+		state := "new"
+		if _, exists := postedKeys[canonicalizer.canonicalize(article.URL)]; exists {
+			state = "posted"
+		} else {
+			newCount++
+		}
+		location := strings.Join(articlePrefectures(article), "・")
+		if article.Municipality != "" {
+			location += " " + article.Municipality
+		}
+		fmt.Printf("[%s] %s %s\n    %s\n    📍 %s\n", state, article.PublishedAt.In(time.FixedZone("JST", JSTOffset)).Format("2006-01-02 15:04"), article.Title, article.URL, location)
+	}
+	fmt.Printf("%d articles (%d new) from source '%s'\n", len(articles), newCount, name)
+
+	for feed, record := range health.records {
+		if record.LastError != "" {
+			fmt.Printf("Failed: %s: %s\n", feed, record.LastError)
+		}
+	}
+	return nil
+}
+
+func runConfigCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return fmt.Errorf("usage: config validate")
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	problems := validateConfig(config)
+
+	if store, err := newStateStore(ctx, config); err != nil {
+		problems = append(problems, fmt.Sprintf("store: %v", err))
+	} else {
+		defer store.Close()
+		if rssConfig, err := loadRSSConfig(ctx, store, config); err != nil {
+			problems = append(problems, fmt.Sprintf("rss config: %v", err))
+		} else {
+			problems = append(problems, validateRSSConfig(rssConfig)...)
+			if _, err := buildServeJobs(config, rssConfig); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println("✗ " + problem)
+		}
+		return fmt.Errorf("found %d configuration problems", len(problems))
+	}
+
+	fmt.Println("✓ Configuration is valid")
+	return nil
+}
+
+// validateConfig は設定ファイルの値を検証し、問題の一覧を返す
+func validateConfig(config *Config) []string {
+	var problems []string
+	if parsed, err := url.Parse(config.Mastodon.Server); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, fmt.Sprintf("mastodon.server: invalid URL '%s'", config.Mastodon.Server))
+	}
+	if config.Mastodon.AccessToken == "" {
+		problems = append(problems, "mastodon.access_token: required")
+	}
+	switch config.Mastodon.Visibility {
+	case "", "public", "unlisted", "private", "direct":
+	default:
+		problems = append(problems, fmt.Sprintf("mastodon.visibility: unknown visibility '%s'", config.Mastodon.Visibility))
+	}
+
+	switch config.Store.Type {
+	case "", StoreTypeS3:
+		if config.AWS.S3.BucketName == "" {
+			problems = append(problems, "aws.s3.bucket_name: required for the s3 store")
+		}
+	case StoreTypeFile, StoreTypeBolt:
+	default:
+		problems = append(problems, fmt.Sprintf("store.type: unknown store type '%s'", config.Store.Type))
+	}
+
+	if config.Misskey.Server != "" {
+		if parsed, err := url.Parse(config.Misskey.Server); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("misskey.server: invalid URL '%s'", config.Misskey.Server))
+		}
+		if config.Misskey.AccessToken == "" {
+			problems = append(problems, "misskey.access_token: required when misskey.server is set")
+		}
+	}
+
+	if config.Bluesky.Identifier != "" {
+		if parsed, err := url.Parse(config.Bluesky.Host); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("bluesky.host: invalid URL '%s'", config.Bluesky.Host))
+		}
+		if config.Bluesky.AppPassword == "" {
+			problems = append(problems, "bluesky.app_password: required when bluesky.identifier is set")
+		}
+	}
+
+	for i, webhook := range config.Webhooks {
+		if parsed, err := url.Parse(webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("webhooks[%d].url: invalid URL '%s'", i, webhook.URL))
+		}
+		switch webhook.Format {
+		case "", WebhookFormatDiscord, WebhookFormatSlack, WebhookFormatJSON:
+		default:
+			problems = append(problems, fmt.Sprintf("webhooks[%d].format: unknown format '%s'", i, webhook.Format))
+		}
+		for _, prefecture := range webhook.Prefectures {
+			if !slices.Contains(prefectures, prefecture) {
+				problems = append(problems, fmt.Sprintf("webhooks[%d].prefectures: unknown prefecture '%s'", i, prefecture))
+			}
+		}
+	}
+
+	if config.Alert.WebhookURL != "" {
+		if parsed, err := url.Parse(config.Alert.WebhookURL); err != nil || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("alert.webhook_url: invalid URL '%s'", config.Alert.WebhookURL))
+		}
+	}
+	if _, err := parseSummaryTime(config.Summary.Time); err != nil {
+		problems = append(problems, fmt.Sprintf("summary.time: %v", err))
+	}
+
+	return problems
+}
+
+// validateRSSConfig はRSS設定ファイルの値を検証し、問題の一覧を返す
+func validateRSSConfig(rssConfig *RSSConfig) []string {
+	var problems []string
+	for _, rssURL := range rssConfig.RSSSources {
+		if parsed, err := url.Parse(rssURL); err != nil || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("rss_sources: invalid URL '%s'", rssURL))
+		}
+	}
+
+	switch rssConfig.NearDuplicate.Mode {
+	case "", NearDuplicateModeOff, NearDuplicateModeSuppress, NearDuplicateModeReply:
+	default:
+		problems = append(problems, fmt.Sprintf("near_duplicate.mode: unknown mode '%s'", rssConfig.NearDuplicate.Mode))
+	}
+	if rssConfig.NearDuplicate.Threshold > 1 {
+		problems = append(problems, fmt.Sprintf("near_duplicate.threshold: %v is greater than 1", rssConfig.NearDuplicate.Threshold))
+	}
+
+	env := &sourceEnv{rssConfig: rssConfig, health: newHealthTracker(make(map[string]*SourceHealth))}
+	if _, err := buildSources(env); err != nil {
+		problems = append(problems, fmt.Sprintf("sources: %v", err))
+	}
+
+	return problems
+}
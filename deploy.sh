@@ -12,7 +12,7 @@ FUNCTION_NAME=${2:-kuma}
 export AWS_PAGER=""
 
 echo "Building kuma_bot for Lambda..."
-GOOS=linux GOARCH=amd64 go build -o bootstrap main.go
+GOOS=linux GOARCH=amd64 go build -o bootstrap .
 
 echo "Creating deployment package..."
 zip kuma_bot.zip bootstrap
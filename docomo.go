@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type docomoSource struct {
+	name    string
+	hashtag string
+	env     *sourceEnv
+}
+
+func newDocomoSource(sourceConfig SourceConfig, env *sourceEnv) (Source, error) {
+	return &docomoSource{
+		name:    sourceConfig.Name,
+		hashtag: hashtagOrDefault(sourceConfig, KumaHashtag),
+		env:     env,
+	}, nil
+}
+
+func (s *docomoSource) Name() string {
+	return s.name
+}
+
+func (s *docomoSource) Hashtag() string {
+	return s.hashtag
+}
+
+func (s *docomoSource) FormatPost(article *PostedURL) string {
+	return fmt.Sprintf(KumaPostTemplate, article.Title, article.URL, article.Description, s.hashtag)
+}
+
+func (s *docomoSource) Fetch(ctx context.Context) ([]PostedURL, error) {
+	var allArticles []*PostedURL
+
+	for page := 1; page <= MaxPages; page++ {
+		doc, err := fetchHTML(ctx, page, s.env.cache)
+		if errors.Is(err, ErrNotModified) {
+			if page == 1 {
+				log.Printf("Page 1 not modified since last fetch, skipping")
+				s.env.health.recordNotModified(s.name)
+				return nil, nil
+			}
+			continue
+		}
+		if err != nil {
+			if page == 1 {
+				s.env.health.recordFailure(s.name, err)
+				return nil, err
+			}
+			log.Printf("Failed to fetch page %d, stopping: %v", page, err)
+			break
+		}
+
+		articles := parseArticles(doc, page)
+		if len(articles) == 0 && page > 1 {
+			log.Printf("No articles found on page %d, stopping", page)
+			break
+		}
+		// 1ページ目が未更新だと以降のページも取得しないため、1ページ目の検証子も戻せるようにする
+		for _, article := range articles {
+			article.fetchedFrom = []string{docomoPageURL(1), docomoPageURL(page)}
+		}
+
+		allArticles = append(allArticles, articles...)
+	}
+
+	s.env.health.recordSuccess(s.name, len(allArticles))
+
+	postedURLs := make([]PostedURL, 0, len(allArticles))
+	for _, article := range allArticles {
+		postedURLs = append(postedURLs, *article)
+	}
+
+	return postedURLs, nil
+}
+
+func fetchHTML(ctx context.Context, page int, cache *httpCache) (*goquery.Document, error) {
+	doc, err := fetchDocument(ctx, docomoPageURL(page), cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page %d: %w", page, err)
+	}
+
+	return doc, nil
+}
+
+func docomoPageURL(page int) string {
+	return fmt.Sprintf("%s?page=%d", KumaNewsURL, page)
+}
+
+func parseArticles(doc *goquery.Document, page int) []*PostedURL {
+	var articles []*PostedURL
+
+	doc.Find("li.h-bm02").Each(func(i int, s *goquery.Selection) {
+		if s.Find("div[data-allox-placement]").Length() > 0 {
+			return
+		}
+
+		article := extractArticleInfo(s, page)
+		if article != nil {
+			articles = append(articles, article)
+		}
+	})
+
+	return articles
+}
+
+func extractArticleInfo(s *goquery.Selection, page int) *PostedURL {
+	thumbsListUnit := s.Find("div.thumbsListUnit")
+	newsListSupplement := thumbsListUnit.Find("p.newsListSupplement")
+	dateText := strings.TrimSpace(newsListSupplement.Find("span.newsDate").Text())
+	timeText := strings.TrimSpace(newsListSupplement.Find("span.newsTime").Text())
+
+	timestamp, err := parseDateTime(dateText, timeText)
+	if err != nil {
+		log.Printf("Skipping article on page %d due to datetime parse error: %v", page, err)
+		return nil
+	}
+
+	title := strings.TrimSpace(thumbsListUnit.Find("h3.thumbsListTitle").Text())
+	href, _ := thumbsListUnit.Find("h3.thumbsListTitle").Closest("a").Attr("href")
+	source := strings.TrimSpace(newsListSupplement.Find("span.newsTenter").Text())
+	region := strings.TrimSpace(s.Find("ul.topics-keywords li a").Text())
+
+	return &PostedURL{
+		Title:       title,
+		URL:         href,
+		Description: fmt.Sprintf("%s %s %s %s", region, source, dateText, timeText),
+		PublishedAt: timestamp,
+		Prefecture:  extractPrefecture(region),
+	}
+}
+
+func parseDateTime(dateText, timeText string) (time.Time, error) {
+	jst := time.FixedZone("JST", JSTOffset)
+	nowJST := time.Now().In(jst)
+
+	if idx := strings.Index(dateText, "("); idx > 0 {
+		dateText = dateText[:idx]
+	}
+
+	dateTimeStr := fmt.Sprintf("%d/%s %s", nowJST.Year(), dateText, timeText)
+
+	parsedTime, err := time.ParseInLocation("2006/1/2 15:4", dateTimeStr, jst)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse datetime '%s %s': %v", dateText, timeText, err)
+	}
+
+	if parsedTime.After(nowJST) {
+		dateTimeStr = fmt.Sprintf("%d/%s %s", nowJST.Year()-1, dateText, timeText)
+		parsedTime, err = time.ParseInLocation("2006/1/2 15:4", dateTimeStr, jst)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse previous year datetime '%s': %v", dateTimeStr, err)
+		}
+	}
+
+	return parsedTime, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	EventModeRun              = "run"
+	EventModeScrape           = "scrape"
+	EventModeSummary          = "summary"
+	EventModeScheduledSummary = "scheduled-summary"
+	EventModeBackfill         = "backfill"
+	EventModeReport           = "report"
+)
+
+// KumaBotEvent はLambdaの呼び出しペイロード。EventBridgeのスケジュールイベントなど項目がない場合は通常の実行（run）になる。
+type KumaBotEvent struct {
+	Mode    string   `json:"mode"`
+	Date    string   `json:"date"`
+	DryRun  bool     `json:"dry_run"`
+	Sources []string `json:"sources"`
+}
+
+// KumaBotResult はLambdaの呼び出し結果
+type KumaBotResult struct {
+	Mode       string         `json:"mode"`
+	Date       string         `json:"date,omitempty"`
+	Backfilled int            `json:"backfilled,omitempty"`
+	Report     *KumaBotReport `json:"report,omitempty"`
+}
+
+// KumaBotReport は投稿せずに返す集計対象日の集計と取得元の稼働状況
+type KumaBotReport struct {
+	Date        string                   `json:"date"`
+	Total       int                      `json:"total"`
+	Prefectures []PrefectureCount        `json:"prefectures"`
+	Sources     map[string]*SourceHealth `json:"sources"`
+}
+
+// eventDate はdate（YYYY-MM-DD、JST）を返す。省略時は前日。
+func eventDate(event KumaBotEvent) (time.Time, error) {
+	jst := time.FixedZone("JST", JSTOffset)
+	if event.Date == "" {
+		now := time.Now().In(jst)
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, jst).AddDate(0, 0, -1), nil
+	}
+
+	date, err := time.ParseInLocation(ArchiveDateFormat, event.Date, jst)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s': %w", event.Date, err)
+	}
+	return date, nil
+}
+
+func validateEventMode(mode string) error {
+	switch mode {
+	case "", EventModeRun, EventModeScrape, EventModeSummary, EventModeScheduledSummary, EventModeBackfill, EventModeReport:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode '%s'", mode)
+	}
+}
+
+type dryRunContextKey struct{}
+
+// withDryRun は投稿と状態の更新を行わない実行のcontextを返す。dry_runを指定した呼び出しはこのcontextで処理する。
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunContextKey{}, true)
+}
+
+// isDryRun は環境変数DRY_RUN=1、またはwithDryRunで指定された実行かを返す
+func isDryRun(ctx context.Context) bool {
+	if dryRun, ok := ctx.Value(dryRunContextKey{}).(bool); ok && dryRun {
+		return true
+	}
+	return os.Getenv("DRY_RUN") == "1"
+}
+
+// backfillArchive はdateのアーカイブがない場合にタイムラインから補完する。投稿はしない。
+func backfillArchive(ctx context.Context, store StateStore, client *mastodon.Client, date time.Time) (int, error) {
+	_, err := loadArchiveRecords(ctx, store, date)
+	if err == nil {
+		log.Printf("Archive for %s already exists, skipping backfill", date.Format(ArchiveDateFormat))
+		return 0, nil
+	}
+	if !errors.Is(err, ErrStateNotFound) {
+		return 0, fmt.Errorf("failed to load archive records: %w", err)
+	}
+
+	records, err := backfillArchiveFromTimeline(ctx, store, client, date)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Backfilled %d archive records for %s", len(records), date.Format(ArchiveDateFormat))
+	return len(records), nil
+}
+
+// buildReport はdateのアーカイブの集計と取得元の稼働状況を返す。状態は変更しない。
+func buildReport(ctx context.Context, store StateStore, date time.Time) (*KumaBotReport, error) {
+	records, err := loadArchiveRecords(ctx, store, date)
+	if err != nil && !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load archive records: %w", err)
+	}
+	stats, total := aggregateArchiveRecords(records)
+
+	health, err := loadHealthTracker(ctx, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source health: %w", err)
+	}
+
+	return &KumaBotReport{
+		Date:        date.Format(ArchiveDateFormat),
+		Total:       total,
+		Prefectures: stats,
+		Sources:     health.records,
+	}, nil
+}
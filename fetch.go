@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	HTTPCacheKey           = "http_cache.json"
+	HTTPCacheRetentionDays = 30
+)
+
+var ErrNotModified = errors.New("not modified since last fetch")
+
+// HTTPValidator は条件付きGETのためにURLごとに保存する検証子
+type HTTPValidator struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// httpCache は実行中に取得したURLの検証子を保持し、実行の最後にまとめて保存する
+type httpCache struct {
+	mu         sync.Mutex
+	validators map[string]HTTPValidator
+	// loaded は読み込み時の検証子。restoreで戻すために保持する。
+	loaded map[string]HTTPValidator
+}
+
+func loadHTTPCache(ctx context.Context, store StateStore) (*httpCache, error) {
+	validators := make(map[string]HTTPValidator)
+	if err := loadJSON(ctx, store, HTTPCacheKey, &validators); err != nil && !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load HTTP cache: %w", err)
+	}
+
+	loaded := make(map[string]HTTPValidator, len(validators))
+	for url, validator := range validators {
+		loaded[url] = validator
+	}
+	return &httpCache{validators: validators, loaded: loaded}, nil
+}
+
+func (c *httpCache) get(url string) (HTTPValidator, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	validator, ok := c.validators[url]
+	return validator, ok
+}
+
+func (c *httpCache) set(url string, validator HTTPValidator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.validators[url] = validator
+}
+
+// restore は指定したURLの検証子を読み込み時の値に戻す（読み込み時になかったURLは削除する）
+func (c *httpCache) restore(urls ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, url := range urls {
+		if validator, ok := c.loaded[url]; ok {
+			c.validators[url] = validator
+		} else {
+			delete(c.validators, url)
+		}
+	}
+}
+
+func (c *httpCache) save(ctx context.Context, store StateStore) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would save %d HTTP validators", len(c.validators))
+		return nil
+	}
+
+	c.mu.Lock()
+	cutoffTime := time.Now().AddDate(0, 0, -HTTPCacheRetentionDays)
+	validators := make(map[string]HTTPValidator, len(c.validators))
+	for url, validator := range c.validators {
+		if validator.CheckedAt.After(cutoffTime) {
+			validators[url] = validator
+		}
+	}
+	c.mu.Unlock()
+
+	if err := saveJSON(ctx, store, HTTPCacheKey, validators); err != nil {
+		return fmt.Errorf("failed to save HTTP cache: %w", err)
+	}
+
+	return nil
+}
+
+// fetchURL はキャッシュ済みの検証子でIf-None-Match / If-Modified-Sinceを送り、
+// 304の場合はErrNotModifiedを返す。タイムアウトは呼び出し側のcontextで指定する。
+func fetchURL(ctx context.Context, url string, cache *httpCache) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	if cache != nil {
+		if validator, ok := cache.get(url); ok {
+			if validator.ETag != "" {
+				req.Header.Set("If-None-Match", validator.ETag)
+			}
+			if validator.LastModified != "" {
+				req.Header.Set("If-Modified-Since", validator.LastModified)
+			}
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if cache != nil {
+			validator, _ := cache.get(url)
+			validator.CheckedAt = time.Now()
+			cache.set(url, validator)
+		}
+		return nil, ErrNotModified
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s not found (404)", url)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error %d when fetching %s", resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", url, err)
+	}
+
+	if cache != nil {
+		cache.set(url, HTTPValidator{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			CheckedAt:    time.Now(),
+		})
+	}
+
+	return body, nil
+}
+
+func fetchDocument(ctx context.Context, url string, cache *httpCache) (*goquery.Document, error) {
+	ctx, cancel := context.WithTimeout(ctx, HTTPTimeout)
+	defer cancel()
+
+	body, err := fetchURL(ctx, url, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		// 解析できなかったページを次回304で読み飛ばさないよう、検証子を戻す
+		if cache != nil {
+			cache.restore(url)
+		}
+		return nil, fmt.Errorf("failed to parse HTML from %s: %w", url, err)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed data/municipalities.tsv
+var municipalityData string
+
+// 同名の市町村の区別に使えない、地名の直前に来うる漢字
+const municipalityPrefixRunes = "都道府県郡"
+
+var municipalityNameReplacer = strings.NewReplacer("ヶ", "ケ", "ヵ", "カ", "ｹ", "ケ")
+
+// Municipality は地名辞書の市区町村
+type Municipality struct {
+	Prefecture string
+	Name       string
+	Latitude   float64
+	Longitude  float64
+}
+
+// gazetteer は市区町村名から候補（同名の市町村は複数）を引く地名辞書
+type gazetteer struct {
+	byName   map[string][]Municipality
+	maxRunes int
+}
+
+var (
+	municipalityGazetteer *gazetteer
+	gazetteerOnce         sync.Once
+)
+
+func loadGazetteer() *gazetteer {
+	gazetteerOnce.Do(func() {
+		municipalityGazetteer = parseGazetteer(municipalityData)
+	})
+	return municipalityGazetteer
+}
+
+func parseGazetteer(data string) *gazetteer {
+	g := &gazetteer{byName: make(map[string][]Municipality)}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			log.Printf("Skipping malformed gazetteer line %d: %q", i+1, line)
+			continue
+		}
+		latitude, latErr := strconv.ParseFloat(fields[2], 64)
+		longitude, lonErr := strconv.ParseFloat(fields[3], 64)
+		if latErr != nil || lonErr != nil {
+			log.Printf("Skipping gazetteer line %d with invalid coordinates: %q", i+1, line)
+			continue
+		}
+
+		municipality := Municipality{
+			Prefecture: fields[0],
+			Name:       fields[1],
+			Latitude:   latitude,
+			Longitude:  longitude,
+		}
+		key := municipalityNameReplacer.Replace(municipality.Name)
+		g.byName[key] = append(g.byName[key], municipality)
+		g.maxRunes = max(g.maxRunes, utf8.RuneCountInString(key))
+	}
+
+	return g
+}
+
+// find はテキスト中の市区町村名を出現順に返す。各位置で最長の名前を採用し、
+// 直前が漢字（都道府県・郡を除く）の場合は別の地名の一部とみなして採用しない（例: 沼津市の「津市」）。
+func (g *gazetteer) find(text string) [][]Municipality {
+	runes := []rune(municipalityNameReplacer.Replace(text))
+
+	var found [][]Municipality
+	for i := 0; i < len(runes); i++ {
+		if i > 0 && unicode.Is(unicode.Han, runes[i-1]) && !strings.ContainsRune(municipalityPrefixRunes, runes[i-1]) {
+			continue
+		}
+
+		for length := min(g.maxRunes, len(runes)-i); length >= 2; length-- {
+			if candidates, ok := g.byName[string(runes[i:i+length])]; ok {
+				found = append(found, candidates)
+				i += length - 1
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// locate はテキスト中で最初に特定できた市区町村を返す。同名の市町村は、テキスト中の都道府県名、
+// 呼び出し側が知っている都道府県、同じテキストに出てくる他の市区町村の都道府県で絞り込み、
+// 1つに絞れない場合は次の地名を見る。テキストに都道府県名がある場合、それと食い違う候補は採用しない
+// （辞書にない同名の市町村を取り違えないため。例: 辞書にない熊本県高森町を長野県高森町としない）。
+func (g *gazetteer) locate(text string, prefectureHints ...string) (Municipality, bool) {
+	named := namedPrefectures(text)
+	var found [][]Municipality
+	for _, candidates := range g.find(text) {
+		if len(named) > 0 {
+			candidates = slices.DeleteFunc(slices.Clone(candidates), func(candidate Municipality) bool {
+				return !slices.Contains(named, candidate.Prefecture)
+			})
+		}
+		if len(candidates) > 0 {
+			found = append(found, candidates)
+		}
+	}
+	if len(found) == 0 {
+		return Municipality{}, false
+	}
+
+	context := make(map[string]struct{})
+	for _, prefecture := range prefectureHints {
+		if prefecture != "" {
+			context[prefecture] = struct{}{}
+		}
+	}
+	for _, prefecture := range named {
+		context[prefecture] = struct{}{}
+	}
+	for _, candidates := range found {
+		if len(candidates) == 1 {
+			context[candidates[0].Prefecture] = struct{}{}
+		}
+	}
+
+	for _, candidates := range found {
+		if len(candidates) == 1 {
+			return candidates[0], true
+		}
+
+		var matched []Municipality
+		for _, candidate := range candidates {
+			if _, ok := context[candidate.Prefecture]; ok {
+				matched = append(matched, candidate)
+			}
+		}
+		if len(matched) == 1 {
+			return matched[0], true
+		}
+	}
+
+	return Municipality{}, false
+}
+
+// namedPrefectures はテキストに正式名で出てくる都道府県を返す
+func namedPrefectures(text string) []string {
+	var named []string
+	for _, prefecture := range prefectures {
+		if strings.Contains(text, prefecture) {
+			named = append(named, prefecture)
+		}
+	}
+	return named
+}
+
+// locateArticle は記事のタイトルと概要から市区町村を特定し、都道府県・市区町村・座標を記事に設定する。
+// 取得元や推定で都道府県が分かっている場合は、それと食い違う市区町村は採用しない。
+func locateArticle(article *PostedURL) {
+	known := articlePrefectures(*article)
+	municipality, ok := loadGazetteer().locate(article.Title+" "+article.Description, known...)
+	if !ok {
+		return
+	}
+	if len(known) > 0 && !slices.Contains(known, municipality.Prefecture) {
+		return
+	}
+
+	if article.Prefecture == "" {
+		article.Prefecture = municipality.Prefecture
+	}
+	article.Municipality = municipality.Name
+	article.Latitude = municipality.Latitude
+	article.Longitude = municipality.Longitude
+}
+
+// 略称・市名の語幹の直後に来れば地名とみなす文字
+const placeBoundaryRunes = "・、。，, 　)）]】」』でのにと"
+
+// landmarkPrefectures は市区町村名を含まずに報じられることの多い地名と、その都道府県
+var landmarkPrefectures = map[string][]string{
+	"知床":   {"北海道"},
+	"大雪山":  {"北海道"},
+	"日高山脈": {"北海道"},
+	"阿寒":   {"北海道"},
+	"十勝":   {"北海道"},
+	"道東":   {"北海道"},
+	"道北":   {"北海道"},
+	"道央":   {"北海道"},
+	"道南":   {"北海道"},
+	"八甲田":  {"青森県"},
+	"津軽":   {"青森県"},
+	"下北半島": {"青森県"},
+	"奥入瀬":  {"青森県"},
+	"白神山地": {"青森県", "秋田県"},
+	"十和田湖": {"青森県", "秋田県"},
+	"岩手山":  {"岩手県"},
+	"田沢湖":  {"秋田県"},
+	"男鹿半島": {"秋田県"},
+	"鳥海山":  {"秋田県", "山形県"},
+	"月山":   {"山形県"},
+	"置賜":   {"山形県"},
+	"蔵王":   {"宮城県", "山形県"},
+	"会津":   {"福島県"},
+	"磐梯山":  {"福島県"},
+	"尾瀬":   {"群馬県", "福島県"},
+	"奥日光":  {"栃木県"},
+	"丹沢":   {"神奈川県"},
+	"上高地":  {"長野県"},
+	"乗鞍":   {"長野県", "岐阜県"},
+	"奥飛騨":  {"岐阜県"},
+	"能登半島": {"石川県"},
+	"富士山":  {"山梨県", "静岡県"},
+	"比叡山":  {"滋賀県", "京都府"},
+	"大台ケ原": {"奈良県", "三重県"},
+	"高野山":  {"和歌山県"},
+	"石鎚山":  {"愛媛県"},
+	"剣山":   {"徳島県"},
+}
+
+type placeKind int
+
+const (
+	// placeExact は前後の文字に関係なく地名とみなす（都道府県名・名所）
+	placeExact placeKind = iota
+	// placeMunicipality は直前が地名の一部でない場合に地名とみなす
+	placeMunicipality
+	// placeAbbreviation はさらに直後が区切り文字か文末の場合だけ地名とみなす（「秋田」などの略称）
+	placeAbbreviation
+	// placeStem は直後が区切り文字の場合だけ地名とみなす（「盛岡」などの市名の語幹。「北上」などの一般語を避けるため文末は除く）
+	placeStem
+)
+
+type placeName struct {
+	kind        placeKind
+	prefectures []string
+	// ambiguous は同名の市町村がある場合で、他の地名から都道府県を絞り込む
+	ambiguous bool
+	// prefectureName は都道府県名またはその略称
+	prefectureName bool
+}
+
+type placeIndex struct {
+	names    map[string]placeName
+	maxRunes int
+}
+
+var (
+	prefecturePlaceIndex *placeIndex
+	placeIndexOnce       sync.Once
+)
+
+func loadPlaceIndex() *placeIndex {
+	placeIndexOnce.Do(func() {
+		prefecturePlaceIndex = buildPlaceIndex(loadGazetteer())
+	})
+	return prefecturePlaceIndex
+}
+
+// buildPlaceIndex は都道府県名・名所・市区町村名・略称・市名の語幹の順に登録する。同じ表記は先に登録したものを優先する。
+func buildPlaceIndex(g *gazetteer) *placeIndex {
+	index := &placeIndex{names: make(map[string]placeName)}
+	add := func(name string, place placeName) {
+		name = municipalityNameReplacer.Replace(name)
+		if _, exists := index.names[name]; exists {
+			return
+		}
+		index.names[name] = place
+		index.maxRunes = max(index.maxRunes, utf8.RuneCountInString(name))
+	}
+
+	for _, prefecture := range prefectures {
+		add(prefecture, placeName{kind: placeExact, prefectures: []string{prefecture}, prefectureName: true})
+	}
+	for name, landmarkPrefectures := range landmarkPrefectures {
+		add(name, placeName{kind: placeExact, prefectures: landmarkPrefectures})
+	}
+
+	stems := make(map[string]map[string]struct{})
+	for name, candidates := range g.byName {
+		var candidatePrefectures []string
+		for _, candidate := range candidates {
+			candidatePrefectures = append(candidatePrefectures, candidate.Prefecture)
+		}
+		add(name, placeName{kind: placeMunicipality, prefectures: candidatePrefectures, ambiguous: len(candidates) > 1})
+
+		stem, ok := strings.CutSuffix(name, "市")
+		if !ok || utf8.RuneCountInString(stem) < 2 || !strings.ContainsFunc(stem, isKanjiOrKatakana) {
+			continue
+		}
+		if stems[stem] == nil {
+			stems[stem] = make(map[string]struct{})
+		}
+		for _, prefecture := range candidatePrefectures {
+			stems[stem][prefecture] = struct{}{}
+		}
+	}
+
+	for _, prefecture := range prefectures {
+		if abbreviation := prefectureAbbreviation(prefecture); abbreviation != prefecture {
+			add(abbreviation, placeName{kind: placeAbbreviation, prefectures: []string{prefecture}, prefectureName: true})
+		}
+	}
+	for stem, stemPrefectures := range stems {
+		// 複数の都道府県にある語幹（伊達、府中など）は略称としては使わない
+		if len(stemPrefectures) != 1 {
+			continue
+		}
+		for prefecture := range stemPrefectures {
+			add(stem, placeName{kind: placeStem, prefectures: []string{prefecture}})
+		}
+	}
+
+	return index
+}
+
+// prefectureAbbreviation は都道府県名から末尾の「都」「府」「県」を除いた略称を返す（北海道はそのまま）
+func prefectureAbbreviation(prefecture string) string {
+	// 「京都府」が「京」にならないよう、末尾の1文字だけを取り除く
+	for _, suffix := range []string{"都", "府", "県"} {
+		if abbreviation, ok := strings.CutSuffix(prefecture, suffix); ok {
+			return abbreviation
+		}
+	}
+	return prefecture
+}
+
+func isKanjiOrKatakana(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Katakana)
+}
+
+func (p placeName) acceptAt(runes []rune, start, length int) bool {
+	if p.kind == placeExact {
+		return true
+	}
+	if start > 0 && unicode.Is(unicode.Han, runes[start-1]) && !strings.ContainsRune(municipalityPrefixRunes, runes[start-1]) {
+		return false
+	}
+	end := start + length
+	switch p.kind {
+	case placeAbbreviation:
+		return end == len(runes) || strings.ContainsRune(placeBoundaryRunes, runes[end])
+	case placeStem:
+		return end < len(runes) && strings.ContainsRune(placeBoundaryRunes, runes[end])
+	default:
+		return true
+	}
+}
+
+// inferPrefectures はテキストに出てくる都道府県名、「秋田」などの略称、名所、市区町村名とその語幹から、
+// 記事に関係する都道府県を出現順に返す。同名の市町村は他の地名から1つに絞れた場合だけ数える。
+// 市区町村名とその語幹は、テキストに出てくる都道府県名と食い違う場合は数えない（辞書にない同名の市町村の取り違えを防ぐ）。
+func inferPrefectures(text string) []string {
+	index := loadPlaceIndex()
+	runes := []rune(municipalityNameReplacer.Replace(text))
+
+	var matches []placeName
+	for i := 0; i < len(runes); i++ {
+		for length := min(index.maxRunes, len(runes)-i); length >= 2; length-- {
+			place, ok := index.names[string(runes[i:i+length])]
+			if !ok || !place.acceptAt(runes, i, length) {
+				continue
+			}
+			matches = append(matches, place)
+			i += length - 1
+			break
+		}
+	}
+
+	var named []string
+	for _, place := range matches {
+		if place.prefectureName {
+			named = append(named, place.prefectures...)
+		}
+	}
+	if len(named) > 0 {
+		matches = slices.DeleteFunc(matches, func(place placeName) bool {
+			return (place.kind == placeMunicipality || place.kind == placeStem) &&
+				!slices.ContainsFunc(place.prefectures, func(prefecture string) bool { return slices.Contains(named, prefecture) })
+		})
+	}
+
+	context := make(map[string]struct{})
+	for _, place := range matches {
+		if !place.ambiguous {
+			for _, prefecture := range place.prefectures {
+				context[prefecture] = struct{}{}
+			}
+		}
+	}
+
+	var result []string
+	seen := make(map[string]struct{})
+	for _, place := range matches {
+		candidates := place.prefectures
+		if place.ambiguous {
+			candidates = nil
+			for _, prefecture := range place.prefectures {
+				if _, ok := context[prefecture]; ok {
+					candidates = append(candidates, prefecture)
+				}
+			}
+			if len(candidates) != 1 {
+				continue
+			}
+		}
+
+		for _, prefecture := range candidates {
+			if _, exists := seen[prefecture]; !exists {
+				seen[prefecture] = struct{}{}
+				result = append(result, prefecture)
+			}
+		}
+	}
+
+	return result
+}
+
+// articlePrefectures は記事に関係する都道府県を返す。RSS記事は複数の場合がある。
+func articlePrefectures(article PostedURL) []string {
+	if len(article.Prefectures) > 0 {
+		return article.Prefectures
+	}
+	if article.Prefecture != "" {
+		return []string{article.Prefecture}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+const testMunicipalityData = `# テスト用の地名辞書
+北海道	伊達市	42.47	140.86
+福島県	伊達市	37.82	140.56
+北海道	森町	42.11	140.58
+静岡県	森町	34.84	137.93
+北海道	函館市	41.77	140.73
+長野県	高森町	35.55	137.87
+長野県	長野市	36.65	138.18
+三重県	津市	34.72	136.51
+`
+
+func TestGazetteerLocate(t *testing.T) {
+	g := parseGazetteer(testMunicipalityData)
+
+	tests := []struct {
+		name             string
+		text             string
+		hints            []string
+		wantOK           bool
+		wantPrefecture   string
+		wantMunicipality string
+	}{
+		{
+			name:             "unique name",
+			text:             "高森町でクマ目撃",
+			wantOK:           true,
+			wantPrefecture:   "長野県",
+			wantMunicipality: "高森町",
+		},
+		{
+			name:             "unique name with matching prefecture",
+			text:             "長野県高森町でクマ目撃",
+			wantOK:           true,
+			wantPrefecture:   "長野県",
+			wantMunicipality: "高森町",
+		},
+		{
+			name:   "unique name contradicted by prefecture in text",
+			text:   "熊本県高森町でクマ目撃",
+			wantOK: false,
+		},
+		{
+			name:   "ambiguous name without context",
+			text:   "伊達市でクマ目撃",
+			wantOK: false,
+		},
+		{
+			name:             "ambiguous name with prefecture in text",
+			text:             "福島県伊達市でクマ目撃",
+			wantOK:           true,
+			wantPrefecture:   "福島県",
+			wantMunicipality: "伊達市",
+		},
+		{
+			name:             "ambiguous name with hint",
+			text:             "伊達市でクマ目撃",
+			hints:            []string{"北海道"},
+			wantOK:           true,
+			wantPrefecture:   "北海道",
+			wantMunicipality: "伊達市",
+		},
+		{
+			name:             "ambiguous name with other municipality",
+			text:             "森町でクマ、函館市でも目撃",
+			wantOK:           true,
+			wantPrefecture:   "北海道",
+			wantMunicipality: "森町",
+		},
+		{
+			name:   "ambiguous name contradicted by prefecture in text",
+			text:   "愛知県伊達市でクマ目撃",
+			wantOK: false,
+		},
+		{
+			name:   "out of list name",
+			text:   "熊本県南小国町でクマ目撃",
+			wantOK: false,
+		},
+		{
+			name:   "name inside another place name",
+			text:   "沼津市でクマ目撃",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := g.locate(tt.text, tt.hints...)
+			if ok != tt.wantOK {
+				t.Fatalf("locate(%q) ok = %v, want %v (got %+v)", tt.text, ok, tt.wantOK, got)
+			}
+			if !ok {
+				return
+			}
+			if got.Prefecture != tt.wantPrefecture || got.Name != tt.wantMunicipality {
+				t.Errorf("locate(%q) = %s %s, want %s %s", tt.text, got.Prefecture, got.Name, tt.wantPrefecture, tt.wantMunicipality)
+			}
+		})
+	}
+}
+
+func TestInferPrefectures(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "prefecture name", text: "岩手県でクマ目撃", want: []string{"岩手県"}},
+		{name: "abbreviations", text: "秋田・岩手でクマ被害", want: []string{"秋田県", "岩手県"}},
+		{name: "landmark", text: "知床でクマ目撃", want: []string{"北海道"}},
+		{name: "city stem", text: "盛岡、住宅街にクマ", want: []string{"岩手県"}},
+		{name: "unique municipality", text: "高森町でクマ目撃", want: []string{"長野県"}},
+		{name: "municipality contradicted by prefecture", text: "熊本県高森町でクマ目撃", want: []string{"熊本県"}},
+		{name: "municipality contradicted by abbreviation", text: "熊本の高森町でクマ目撃", want: []string{"熊本県"}},
+		{name: "kyoto prefecture", text: "京都府でクマ目撃", want: []string{"京都府"}},
+		{name: "kyoto abbreviation at end of text", text: "クマの目撃が相次ぐ京都", want: []string{"京都府"}},
+		{name: "municipality contradicted by kyoto abbreviation", text: "京都の高森町でクマ目撃", want: []string{"京都府"}},
+		{name: "ambiguous municipality without context", text: "伊達市でクマ目撃", want: nil},
+		{name: "ambiguous municipality with prefecture", text: "福島県伊達市でクマ目撃", want: []string{"福島県"}},
+		{name: "ambiguous municipality with other municipality", text: "森町でクマ、函館市でも目撃", want: []string{"北海道"}},
+		{name: "out of list municipality", text: "南小国町でクマ目撃", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inferPrefectures(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("inferPrefectures(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefectureAbbreviation(t *testing.T) {
+	tests := map[string]string{
+		"北海道": "北海道",
+		"東京都": "東京",
+		"京都府": "京都",
+		"大阪府": "大阪",
+		"岩手県": "岩手",
+	}
+	for prefecture, want := range tests {
+		if got := prefectureAbbreviation(prefecture); got != want {
+			t.Errorf("prefectureAbbreviation(%q) = %q, want %q", prefecture, got, want)
+		}
+		if got, ok := normalizePrefecture(want); !ok || got != prefecture {
+			t.Errorf("normalizePrefecture(%q) = %q, %v, want %q", want, got, ok, prefecture)
+		}
+	}
+}
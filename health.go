@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	SourceHealthKey           = "source_health.json"
+	SourceHealthRetentionDays = 30
+	DefaultAlertFailureHours  = 6
+	DefaultAlertEmptyHours    = 24
+	DefaultAlertRepeatHours   = 24
+	AlertTemplate             = `⚠️ kuma_bot 取得元の異常
+%s`
+	SummaryAlertTemplate = `⚠️ kuma_bot 集計の投稿に失敗しました
+%v`
+)
+
+// SourceHealth は取得元（RSSはフィード単位）ごとの取得状況
+type SourceHealth struct {
+	FirstSeenAt         time.Time `json:"first_seen_at"`
+	LastAttemptAt       time.Time `json:"last_attempt_at"`
+	LastSuccessAt       time.Time `json:"last_success_at,omitempty"`
+	LastNonEmptyAt      time.Time `json:"last_non_empty_at,omitempty"`
+	FailingSince        time.Time `json:"failing_since,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	ItemsSeen           int       `json:"items_seen"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastAlertAt         time.Time `json:"last_alert_at,omitempty"`
+}
+
+type healthTracker struct {
+	mu      sync.Mutex
+	records map[string]*SourceHealth
+	// attempted はこの実行で取得を試みた取得元。設定から外した取得元や今回対象外の取得元は通知の対象にしない。
+	attempted map[string]struct{}
+}
+
+func loadHealthTracker(ctx context.Context, store StateStore) (*healthTracker, error) {
+	records := make(map[string]*SourceHealth)
+	if err := loadJSON(ctx, store, SourceHealthKey, &records); err != nil && !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load source health: %w", err)
+	}
+
+	return newHealthTracker(records), nil
+}
+
+func newHealthTracker(records map[string]*SourceHealth) *healthTracker {
+	return &healthTracker{records: records, attempted: make(map[string]struct{})}
+}
+
+func (t *healthTracker) record(name string) *SourceHealth {
+	now := time.Now()
+	health, ok := t.records[name]
+	if !ok {
+		health = &SourceHealth{FirstSeenAt: now}
+		t.records[name] = health
+	}
+	health.LastAttemptAt = now
+	t.attempted[name] = struct{}{}
+	return health
+}
+
+func (t *healthTracker) recordSuccess(name string, items int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health := t.record(name)
+	health.LastSuccessAt = health.LastAttemptAt
+	health.FailingSince = time.Time{}
+	health.ConsecutiveFailures = 0
+	health.ItemsSeen = items
+	health.LastError = ""
+	if items > 0 {
+		health.LastNonEmptyAt = health.LastAttemptAt
+	}
+}
+
+// recordNotModified は304応答を記録する。前回の解析結果がそのまま有効なので、前回取得件数があれば空とはみなさない。
+func (t *healthTracker) recordNotModified(name string) {
+	t.mu.Lock()
+	items := 0
+	if health, ok := t.records[name]; ok {
+		items = health.ItemsSeen
+	}
+	t.mu.Unlock()
+
+	t.recordSuccess(name, items)
+}
+
+func (t *healthTracker) recordFailure(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health := t.record(name)
+	if health.ConsecutiveFailures == 0 {
+		health.FailingSince = health.LastAttemptAt
+	}
+	health.ConsecutiveFailures++
+	health.LastError = err.Error()
+}
+
+func (t *healthTracker) save(ctx context.Context, store StateStore) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would save health of %d sources", len(t.records))
+		return nil
+	}
+
+	t.mu.Lock()
+	cutoffTime := time.Now().AddDate(0, 0, -SourceHealthRetentionDays)
+	records := make(map[string]*SourceHealth, len(t.records))
+	for name, health := range t.records {
+		if health.LastAttemptAt.After(cutoffTime) {
+			records[name] = health
+		}
+	}
+	t.mu.Unlock()
+
+	if err := saveJSON(ctx, store, SourceHealthKey, records); err != nil {
+		return fmt.Errorf("failed to save source health: %w", err)
+	}
+
+	return nil
+}
+
+// alertUnhealthySources は今回の実行で取得した取得元のうち、一定時間失敗し続けている、
+// または何も取得できていない取得元を管理者に通知する。同じ取得元への通知はrepeat_hoursごとに1回まで。
+func alertUnhealthySources(ctx context.Context, config *Config, client *mastodon.Client, tracker *healthTracker) {
+	alertConfig := config.Alert
+	if alertConfig.AdminAccount == "" && alertConfig.WebhookURL == "" {
+		return
+	}
+
+	now := time.Now()
+	failureThreshold := hoursOrDefault(alertConfig.FailureHours, DefaultAlertFailureHours)
+	emptyThreshold := hoursOrDefault(alertConfig.EmptyHours, DefaultAlertEmptyHours)
+	repeatInterval := hoursOrDefault(alertConfig.RepeatHours, DefaultAlertRepeatHours)
+
+	tracker.mu.Lock()
+	var names []string
+	for name := range tracker.attempted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	var alerted []*SourceHealth
+	for _, name := range names {
+		health := tracker.records[name]
+
+		var problem string
+		lastNonEmpty := health.LastNonEmptyAt
+		if lastNonEmpty.IsZero() {
+			lastNonEmpty = health.FirstSeenAt
+		}
+		switch {
+		case health.ConsecutiveFailures > 0 && now.Sub(health.FailingSince) >= failureThreshold:
+			problem = fmt.Sprintf("・%s: %s から%d回連続で失敗（%s）", name, formatJST(health.FailingSince), health.ConsecutiveFailures, health.LastError)
+		case health.ConsecutiveFailures == 0 && now.Sub(lastNonEmpty) >= emptyThreshold:
+			problem = fmt.Sprintf("・%s: %s から記事を1件も取得できていません", name, formatJST(lastNonEmpty))
+		default:
+			health.LastAlertAt = time.Time{}
+			continue
+		}
+
+		if now.Sub(health.LastAlertAt) < repeatInterval {
+			continue
+		}
+		problems = append(problems, problem)
+		alerted = append(alerted, health)
+	}
+	tracker.mu.Unlock()
+
+	if len(problems) == 0 {
+		return
+	}
+
+	message := fmt.Sprintf(AlertTemplate, strings.Join(problems, "\n"))
+	if err := sendAlert(ctx, config, client, message); err != nil {
+		log.Printf("Failed to send source health alert: %v", err)
+		return
+	}
+
+	tracker.mu.Lock()
+	for _, health := range alerted {
+		health.LastAlertAt = now
+	}
+	tracker.mu.Unlock()
+}
+
+func sendAlert(ctx context.Context, config *Config, client *mastodon.Client, message string) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would send alert:\n%s", message)
+		return nil
+	}
+
+	var errs []error
+	if config.Alert.AdminAccount != "" {
+		_, err := client.PostStatus(ctx, &mastodon.Toot{
+			Status:     fmt.Sprintf("@%s %s", strings.TrimPrefix(config.Alert.AdminAccount, "@"), message),
+			Visibility: "direct",
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to send alert DM: %w", err))
+		}
+	}
+
+	if config.Alert.WebhookURL != "" {
+		if err := postAlertWebhook(ctx, config.Alert.WebhookURL, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// postAlertWebhook はSlack互換（text）とDiscord互換（content）の両方のフィールドを持つJSONを送る
+func postAlertWebhook(ctx context.Context, webhookURL, message string) error {
+	payload, err := json.Marshal(map[string]string{
+		"text":    message,
+		"content": message,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create alert webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: HTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func hoursOrDefault(hours, defaultHours int) time.Duration {
+	if hours <= 0 {
+		hours = defaultHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func formatJST(t time.Time) string {
+	return t.In(time.FixedZone("JST", JSTOffset)).Format("1/2 15:04")
+}
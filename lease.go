@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	RunLeaseKey = "run_lease.json"
+	RunLeaseTTL = 15 * time.Minute
+)
+
+var ErrLeaseHeld = errors.New("run lease is held by another invocation")
+
+// RunLease は同時に1つの実行だけが投稿するためのリース
+type RunLease struct {
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	version string
+}
+
+func acquireRunLease(ctx context.Context, store StateStore) (*RunLease, error) {
+	var current RunLease
+	version, err := loadJSONVersioned(ctx, store, RunLeaseKey, &current)
+	if err != nil && !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load run lease: %w", err)
+	}
+
+	now := time.Now()
+	if err == nil && now.Before(current.ExpiresAt) {
+		return nil, fmt.Errorf("%w: owner=%s expires_at=%s", ErrLeaseHeld, current.Owner, current.ExpiresAt.Format(time.RFC3339))
+	}
+
+	lease := &RunLease{
+		Owner:      leaseOwnerID(ctx),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(RunLeaseTTL),
+	}
+
+	newVersion, err := saveJSONIf(ctx, store, RunLeaseKey, lease, version)
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return nil, fmt.Errorf("%w: lost race while acquiring", ErrLeaseHeld)
+		}
+		return nil, fmt.Errorf("failed to save run lease: %w", err)
+	}
+	lease.version = newVersion
+
+	return lease, nil
+}
+
+func releaseRunLease(ctx context.Context, store StateStore, lease *RunLease) {
+	released := *lease
+	released.ExpiresAt = time.Now()
+
+	if _, err := saveJSONIf(ctx, store, RunLeaseKey, &released, lease.version); err != nil {
+		log.Printf("Failed to release run lease: %v", err)
+	}
+}
+
+func leaseOwnerID(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return lc.AwsRequestID
+	}
+
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
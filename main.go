@@ -1,27 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/mattn/go-mastodon"
-	"github.com/mmcdole/gofeed"
 )
 
 const (
@@ -31,16 +25,23 @@ const (
 	TootFetchLimit         = 40
 	JSTOffset              = 9 * 60 * 60
 	PostDelay              = 200 * time.Millisecond
+	SaveMaxAttempts        = 5
+	SaveRetryDelay         = 500 * time.Millisecond
 	HTTPTimeout            = 30 * time.Second
+	UserAgent              = "kuma_bot/1.0 (bear sighting news bot)"
+	DefaultRSSConcurrency  = 8
 	OtherPrefecture        = "その他"
-	SummaryTime            = "0:00"
+	SourceDocomo           = "docomo"
+	SourceRSS              = "rss"
+	KumaHashtag            = "#クマ出没情報"
+	RSSHashtag             = "#クマ関連ニュース"
 	KumaPostTemplate       = `🐻 %s
 
 🔗 %s
 
 📍 %s
 
-#クマ出没情報`
+%s`
 
 	SummaryPostTemplate = `🐻 %sのクマ出没情報集計（全%d件）
 ※あくまで出没情報記事数の集計なので実際の出没数とは限りません
@@ -52,9 +53,9 @@ const (
 
 	RSSNewsTemplate = `📰 クマ関連ニュース：%s
 
-%s%s
+%s%s%s
 
-#クマ関連ニュース`
+%s`
 
 	prefecturePattern = `📍\s*([^\n📍]+)`
 )
@@ -71,13 +72,6 @@ var (
 	}
 )
 
-// RSS設定のグローバル変数
-var (
-	rssConfig     *RSSConfig
-	rssConfigOnce sync.Once
-	rssConfigErr  error
-)
-
 type MastodonConfig struct {
 	Server       string `json:"server"`
 	ClientID     string `json:"client_id"`
@@ -97,17 +91,53 @@ type AWSConfig struct {
 	S3     S3Config `json:"s3"`
 }
 
+type StoreConfig struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type AlertConfig struct {
+	AdminAccount string `json:"admin_account"`
+	WebhookURL   string `json:"webhook_url"`
+	FailureHours int    `json:"failure_hours"`
+	EmptyHours   int    `json:"empty_hours"`
+	RepeatHours  int    `json:"repeat_hours"`
+}
+
 type Config struct {
-	Mastodon MastodonConfig `json:"mastodon"`
-	AWS      AWSConfig      `json:"aws"`
+	Mastodon      MastodonConfig     `json:"mastodon"`
+	AWS           AWSConfig          `json:"aws"`
+	Store         StoreConfig        `json:"store"`
+	Alert         AlertConfig        `json:"alert"`
+	Summary       SummaryConfig      `json:"summary"`
+	Serve         ServeConfig        `json:"serve"`
+	Misskey       MisskeyConfig      `json:"misskey"`
+	Bluesky       BlueskyConfig      `json:"bluesky"`
+	Webhooks      []WebhookConfig    `json:"webhooks"`
+	Subscriptions SubscriptionConfig `json:"subscriptions"`
 }
 
 type PostedURL struct {
-	URL         string    `json:"url"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	PublishedAt time.Time `json:"published_at"`
-	PostedAt    time.Time `json:"posted_at"`
+	URL          string    `json:"url"`
+	CanonicalURL string    `json:"canonical_url,omitempty"`
+	AliasURL     string    `json:"alias_url,omitempty"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	PublishedAt  time.Time `json:"published_at"`
+	PostedAt     time.Time `json:"posted_at"`
+	Prefecture   string    `json:"prefecture,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	StatusID     string    `json:"status_id,omitempty"`
+	DuplicateOf  string    `json:"duplicate_of,omitempty"`
+	Prefectures  []string  `json:"prefectures,omitempty"`
+	Municipality string    `json:"municipality,omitempty"`
+	Latitude     float64   `json:"latitude,omitempty"`
+	Longitude    float64   `json:"longitude,omitempty"`
+	Location     string    `json:"location,omitempty"`
+
+	// fetchedFrom は記事を取得したページ・フィードのURL。投稿に失敗した場合にその検証子を戻すために使い、保存しない。
+	fetchedFrom []string
 }
 
 type PrefectureCount struct {
@@ -116,16 +146,23 @@ type PrefectureCount struct {
 }
 
 type RSSConfig struct {
-	IncludeKeywords []string `json:"include_keywords"`
-	ExcludeKeywords []string `json:"exclude_keywords"`
-	RSSSources      []string `json:"rss_sources"`
+	IncludeKeywords    []string            `json:"include_keywords"`
+	ExcludeKeywords    []string            `json:"exclude_keywords"`
+	RSSSources         []string            `json:"rss_sources"`
+	MaxConcurrency     int                 `json:"max_concurrency"`
+	FeedTimeoutSeconds int                 `json:"feed_timeout_seconds"`
+	FeedTimeouts       map[string]int      `json:"feed_timeouts"`
+	TrackingParams     []string            `json:"tracking_params"`
+	ResolveCanonical   bool                `json:"resolve_canonical"`
+	NearDuplicate      NearDuplicateConfig `json:"near_duplicate"`
+	Sources            []SourceConfig      `json:"sources"`
 }
 
 func main() {
 	if isLambda() {
 		lambda.Start(handleKumaBotRequest)
 	} else {
-		if err := handleKumaBotRequest(context.Background()); err != nil {
+		if err := runCLI(context.Background(), os.Args[1:]); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -135,58 +172,186 @@ func isLambda() bool {
 	return len(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")) > 0
 }
 
-func handleKumaBotRequest(ctx context.Context) error {
+func handleKumaBotRequest(ctx context.Context, event KumaBotEvent) (*KumaBotResult, error) {
+	if err := validateEventMode(event.Mode); err != nil {
+		return nil, err
+	}
+	mode := event.Mode
+	if mode == "" {
+		mode = EventModeRun
+	}
+	result := &KumaBotResult{Mode: mode}
+
+	date, err := eventDate(event)
+	if err != nil {
+		return nil, err
+	}
+	if mode == EventModeSummary || mode == EventModeBackfill || mode == EventModeReport {
+		result.Date = date.Format(ArchiveDateFormat)
+	}
+
+	if event.DryRun {
+		log.Println("Dry run requested by event")
+		ctx = withDryRun(ctx)
+	}
+
 	config, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	rssConfig, err := loadRSSConfig(ctx, config)
+	store, err := newStateStore(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to load RSS config: %w", err)
+		return nil, fmt.Errorf("failed to create state store: %w", err)
+	}
+	defer store.Close()
+
+	// レポートは状態を変更しないのでリースを取らない
+	if mode == EventModeReport {
+		report, err := buildReport(ctx, store, date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build report: %w", err)
+		}
+		result.Report = report
+		return result, nil
+	}
+
+	if !isDryRun(ctx) {
+		lease, err := acquireRunLease(ctx, store)
+		if err != nil {
+			if errors.Is(err, ErrLeaseHeld) {
+				log.Printf("Skipping run: %v", err)
+				return result, nil
+			}
+			return nil, fmt.Errorf("failed to acquire run lease: %w", err)
+		}
+		defer releaseRunLease(ctx, store, lease)
 	}
 
 	client := newMastodonClient(config)
 
-	if isSummary, err := isSummaryTime(); err != nil {
-		return fmt.Errorf("failed to check summary time: %w", err)
-	} else if isSummary || os.Getenv("KUMA_FORCE_SUMMARY") != "" {
-		log.Println("Starting prefecture summary mode")
-		if err := runPrefectureSummary(ctx, config, client); err != nil {
-			return fmt.Errorf("failed to run prefecture summary: %w", err)
+	switch mode {
+	case EventModeSummary:
+		if err := runSummaryForDate(ctx, store, config, client, date, ""); err != nil {
+			return nil, err
+		}
+		return result, nil
+	case EventModeScheduledSummary:
+		if err := runScheduledSummaries(ctx, store, config, client, ""); err != nil {
+			return nil, fmt.Errorf("failed to run summaries: %w", err)
+		}
+		return result, nil
+	case EventModeBackfill:
+		backfilled, err := backfillArchive(ctx, store, client, date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to backfill archive: %w", err)
 		}
-		log.Println("Completed prefecture summary mode")
+		result.Backfilled = backfilled
+		return result, nil
+	}
+
+	rssConfig, err := loadRSSConfig(ctx, store, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load RSS config: %w", err)
 	}
 
+	// 集計の失敗で出没情報の投稿が止まらないよう、失敗は通知して取得・投稿を続ける。抜けた集計は次回の実行で投稿する。
+	if mode == EventModeRun {
+		if err := runScheduledSummaries(ctx, store, config, client, os.Getenv("KUMA_FORCE_SUMMARY")); err != nil {
+			log.Printf("Failed to run summaries: %v", err)
+			if alertErr := sendAlert(ctx, config, client, fmt.Sprintf(SummaryAlertTemplate, err)); alertErr != nil {
+				log.Printf("Failed to send summary alert: %v", alertErr)
+			}
+		}
+	}
+
+	if err := runScrape(ctx, store, config, rssConfig, client, event.Sources); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// runScrape は取得元から新しい記事を集めて投稿する。sourceNamesを指定するとその取得元だけを対象にする。
+func runScrape(ctx context.Context, store StateStore, config *Config, rssConfig *RSSConfig, client *mastodon.Client, sourceNames []string) error {
 	log.Println("Starting normal mode - checking bear sightings")
-	existingURLs, err := loadPostedURLs(ctx, config)
+	existingURLs, err := loadPostedURLs(ctx, store, config)
 	if err != nil {
 		return fmt.Errorf("failed to load posted URLs: %w", err)
 	}
 
+	existingURLs, err = reconcilePendingPosts(ctx, store, config, client, existingURLs)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile pending posts: %w", err)
+	}
+
 	existingURLs = cleanupOldURLs(existingURLs)
 
+	if config.Subscriptions.Enabled {
+		if err := processSubscriptionCommands(ctx, store, client); err != nil {
+			log.Printf("Failed to process subscription commands: %v", err)
+		}
+	}
+
+	canonicalizer := newURLCanonicalizer(rssConfig)
 	existingURLMap := make(map[string]struct{})
 	for _, posted := range existingURLs {
-		existingURLMap[posted.URL] = struct{}{}
+		existingURLMap[canonicalizer.dedupeKey(posted)] = struct{}{}
+		if posted.AliasURL != "" {
+			existingURLMap[posted.AliasURL] = struct{}{}
+		}
 	}
 
-	kumaArticles, err := processKumaNews(existingURLMap)
+	cache, err := loadHTTPCache(ctx, store)
 	if err != nil {
-		return fmt.Errorf("failed to process kuma news: %w", err)
+		return fmt.Errorf("failed to load HTTP cache: %w", err)
 	}
 
-	rssArticles, err := processRSSNews(existingURLMap, rssConfig)
+	health, err := loadHealthTracker(ctx, store)
 	if err != nil {
-		return fmt.Errorf("failed to process RSS news: %w", err)
+		return fmt.Errorf("failed to load source health: %w", err)
 	}
 
-	if len(kumaArticles) > 0 || len(rssArticles) > 0 {
-		successfullyPostedURLs := postToMastodon(ctx, config, client, kumaArticles, rssArticles)
+	sources, err := buildSources(&sourceEnv{rssConfig: rssConfig, cache: cache, health: health})
+	if err != nil {
+		return fmt.Errorf("failed to build sources: %w", err)
+	}
+	sources, err = filterSources(sources, sourceNames)
+	if err != nil {
+		return err
+	}
 
-		if err := savePostedURLs(ctx, config, append(existingURLs, successfullyPostedURLs...)); err != nil {
-			return fmt.Errorf("failed to save posted URLs: %w", err)
+	batches, fetchErr := collectNewArticles(ctx, sources, canonicalizer, existingURLMap)
+	alertUnhealthySources(ctx, config, client, health)
+	if err := health.save(ctx, store); err != nil {
+		log.Printf("Failed to save source health: %v", err)
+	}
+	if fetchErr != nil {
+		return fetchErr
+	}
+
+	detector := newNearDuplicateDetector(rssConfig.NearDuplicate, existingURLs)
+	detector.mark(batches)
+
+	var failed []PostedURL
+	if len(batches) > 0 {
+		failed, err = postToMastodon(ctx, store, config, client, batches, detector)
+		if err != nil {
+			return fmt.Errorf("failed to post to Mastodon: %w", err)
 		}
+	} else {
+		// 新しい記事がなくても、前回の上限を超えて送れなかった通知は送る
+		notifySubscribers(ctx, store, config, client, nil)
+	}
+
+	// 投稿に失敗した記事は取得元が更新されなくても次回再取得できるよう、その取得元のページの検証子だけを戻す
+	if len(failed) > 0 {
+		log.Printf("Keeping previous HTTP validators so that %d failed articles are fetched again", len(failed))
+		for _, article := range failed {
+			cache.restore(article.fetchedFrom...)
+		}
+	}
+	if err := cache.save(ctx, store); err != nil {
+		return fmt.Errorf("failed to save HTTP cache: %w", err)
 	}
 
 	return nil
@@ -194,7 +359,7 @@ func handleKumaBotRequest(ctx context.Context) error {
 
 func loadConfig() (*Config, error) {
 	if isLambda() {
-		return &Config{
+		config := &Config{
 			Mastodon: MastodonConfig{
 				Server:       os.Getenv("MASTODON_SERVER"),
 				ClientID:     os.Getenv("MASTODON_CLIENT_ID"),
@@ -210,7 +375,41 @@ func loadConfig() (*Config, error) {
 					RSSConfigKey: os.Getenv("S3_RSS_CONFIG_KEY"),
 				},
 			},
-		}, nil
+			Store: StoreConfig{
+				Type: os.Getenv("STATE_STORE_TYPE"),
+				Path: os.Getenv("STATE_STORE_PATH"),
+			},
+			Alert: AlertConfig{
+				AdminAccount: os.Getenv("ALERT_ADMIN_ACCOUNT"),
+				WebhookURL:   os.Getenv("ALERT_WEBHOOK_URL"),
+				FailureHours: getEnvInt("ALERT_FAILURE_HOURS"),
+				EmptyHours:   getEnvInt("ALERT_EMPTY_HOURS"),
+				RepeatHours:  getEnvInt("ALERT_REPEAT_HOURS"),
+			},
+			Summary: SummaryConfig{
+				Time:        os.Getenv("SUMMARY_TIME"),
+				CatchUpDays: getEnvInt("SUMMARY_CATCH_UP_DAYS"),
+			},
+			Misskey: MisskeyConfig{
+				Server:      os.Getenv("MISSKEY_SERVER"),
+				AccessToken: os.Getenv("MISSKEY_ACCESS_TOKEN"),
+			},
+			Subscriptions: SubscriptionConfig{
+				Enabled: getEnvBool("SUBSCRIPTIONS_ENABLED"),
+			},
+			Bluesky: BlueskyConfig{
+				Host:        os.Getenv("BLUESKY_HOST"),
+				Identifier:  os.Getenv("BLUESKY_IDENTIFIER"),
+				AppPassword: os.Getenv("BLUESKY_APP_PASSWORD"),
+			},
+		}
+		if webhooks := os.Getenv("WEBHOOKS"); webhooks != "" {
+			if err := json.Unmarshal([]byte(webhooks), &config.Webhooks); err != nil {
+				return nil, fmt.Errorf("failed to parse WEBHOOKS: %w", err)
+			}
+		}
+		applyConfigDefaults(config)
+		return config, nil
 	}
 
 	file, err := os.Open("config.json")
@@ -225,9 +424,48 @@ func loadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to decode config.json: %w", err)
 	}
 
+	applyConfigDefaults(&config)
 	return &config, nil
 }
 
+func applyConfigDefaults(config *Config) {
+	if config.AWS.S3.ObjectKey == "" {
+		config.AWS.S3.ObjectKey = DefaultPostedURLsKey
+	}
+	if config.AWS.S3.RSSConfigKey == "" {
+		config.AWS.S3.RSSConfigKey = DefaultRSSConfigKey
+	}
+	if config.Summary.Time == "" {
+		config.Summary.Time = DefaultSummaryTime
+	}
+	if config.Summary.CatchUpDays <= 0 {
+		config.Summary.CatchUpDays = DefaultSummaryCatchUpDays
+	}
+	if config.Serve.ScrapeIntervalMinutes <= 0 {
+		config.Serve.ScrapeIntervalMinutes = DefaultScrapeIntervalMinutes
+	}
+	if config.Serve.JitterSeconds == nil {
+		jitterSeconds := DefaultServeJitterSeconds
+		config.Serve.JitterSeconds = &jitterSeconds
+	}
+	if config.Bluesky.Host == "" {
+		config.Bluesky.Host = DefaultBlueskyHost
+	}
+}
+
+func getEnvInt(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func getEnvBool(key string) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && value
+}
+
 func getAWSRegion() string {
 	if region := os.Getenv("KUMA_AWS_REGION"); region != "" {
 		return region
@@ -238,86 +476,48 @@ func getAWSRegion() string {
 	return "ap-northeast-1"
 }
 
-func loadRSSConfig(ctx context.Context, appConfig *Config) (*RSSConfig, error) {
-	rssConfigOnce.Do(func() {
-		if appConfig.AWS.S3.RSSConfigKey == "" {
-			rssConfigErr = fmt.Errorf("RSS config key not specified in config")
-			return
-		}
-		var config RSSConfig
-		if err := loadJSONFromS3(ctx, appConfig, appConfig.AWS.S3.RSSConfigKey, &config); err != nil {
-			rssConfigErr = fmt.Errorf("failed to load RSS config: %w", err)
-			return
-		}
-		rssConfig = &config
-	})
-	return rssConfig, rssConfigErr
+// loadRSSConfig は実行ごとにRSS設定を読み込む。常駐モードでも設定の変更が次の実行から反映される。
+func loadRSSConfig(ctx context.Context, store StateStore, appConfig *Config) (*RSSConfig, error) {
+	var config RSSConfig
+	if err := loadJSON(ctx, store, appConfig.AWS.S3.RSSConfigKey, &config); err != nil {
+		return nil, fmt.Errorf("failed to load RSS config: %w", err)
+	}
+	return &config, nil
 }
 
-func loadPostedURLs(ctx context.Context, appConfig *Config) ([]PostedURL, error) {
+func loadPostedURLs(ctx context.Context, store StateStore, appConfig *Config) ([]PostedURL, error) {
 	var postedURLs []PostedURL
-	if err := loadJSONFromS3(ctx, appConfig, appConfig.AWS.S3.ObjectKey, &postedURLs); err != nil {
+	if err := loadJSON(ctx, store, appConfig.AWS.S3.ObjectKey, &postedURLs); err != nil {
+		if errors.Is(err, ErrStateNotFound) {
+			log.Printf("No posted URLs found at '%s', starting fresh", appConfig.AWS.S3.ObjectKey)
+			return nil, nil
+		}
 		return nil, fmt.Errorf("failed to load posted URLs: %w", err)
 	}
 
 	return postedURLs, nil
 }
 
-func loadJSONFromS3[T any](ctx context.Context, appConfig *Config, key string, target *T) error {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appConfig.AWS.Region))
-	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
-	}
-
-	svc := s3.NewFromConfig(cfg)
-
-	result, err := svc.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(appConfig.AWS.S3.BucketName),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to get object from S3: %w", err)
-	}
-	defer result.Body.Close()
-
-	if err := json.NewDecoder(result.Body).Decode(target); err != nil {
-		return fmt.Errorf("failed to unmarshal JSON from S3: %w", err)
-	}
-
-	return nil
-}
-
 func newMastodonClient(config *Config) *mastodon.Client {
-	return mastodon.NewClient(&mastodon.Config{
+	client := mastodon.NewClient(&mastodon.Config{
 		Server:       config.Mastodon.Server,
 		ClientID:     config.Mastodon.ClientID,
 		ClientSecret: config.Mastodon.ClientSecret,
 		AccessToken:  config.Mastodon.AccessToken,
 	})
+	client.Transport = &idempotencyTransport{base: http.DefaultTransport}
+	return client
 }
 
-func isSummaryTime() (bool, error) {
-	jst := time.FixedZone("JST", JSTOffset)
-	now := time.Now().In(jst)
-
-	targetTime, err := time.ParseInLocation("15:04", SummaryTime, jst)
-	if err != nil {
-		return false, fmt.Errorf("invalid SummaryTime constant '%s': %w", SummaryTime, err)
-	}
-	return now.Hour() == targetTime.Hour() && now.Minute() == targetTime.Minute(), nil
-}
-
-func runPrefectureSummary(ctx context.Context, config *Config, client *mastodon.Client) error {
-	jst := time.FixedZone("JST", JSTOffset)
-	prev := time.Now().In(jst).AddDate(0, 0, -1)
-	yesterday := time.Date(prev.Year(), prev.Month(), prev.Day(), 0, 0, 0, 0, jst)
-
-	toots, err := fetchRecentToots(ctx, client, yesterday)
+// runPrefectureSummary はdate（JSTの0時）の日別集計を投稿する
+func runPrefectureSummary(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, date time.Time) error {
+	records, err := loadSummaryRecords(ctx, store, client, date)
 	if err != nil {
-		return fmt.Errorf("failed to fetch recent toots: %w", err)
+		return fmt.Errorf("failed to load summary records: %w", err)
 	}
 
-	if err := postPrefectureSummary(ctx, config, client, toots, yesterday); err != nil {
+	prefectureStats, totalPosts := aggregateArchiveRecords(records)
+	if err := postPrefectureSummary(ctx, config, client, prefectureStats, totalPosts, date); err != nil {
 		return fmt.Errorf("failed to post prefecture summary: %w", err)
 	}
 
@@ -337,159 +537,147 @@ func cleanupOldURLs(existingURLs []PostedURL) []PostedURL {
 	return validURLs
 }
 
-func processKumaNews(existingURLMap map[string]struct{}) ([]PostedURL, error) {
-	var allArticles []*PostedURL
-
-	for page := 1; page <= MaxPages; page++ {
-		doc, err := fetchHTML(page)
-		if err != nil {
-			if page == 1 {
-				return nil, err
-			}
-			log.Printf("Failed to fetch page %d, stopping: %v", page, err)
-			break
-		}
-
-		articles := parseArticles(doc, page)
-		if len(articles) == 0 && page > 1 {
-			log.Printf("No articles found on page %d, stopping", page)
-			break
-		}
-
-		allArticles = append(allArticles, articles...)
+// postToMastodon は投稿前に記事を投稿中として予約し、投稿に成功した記事から順に投稿済みとして記録する。
+// 途中で実行が中断しても、予約済みの記事は次回の実行でreconcilePendingPostsにより整合される。
+// 投稿した記事（類似記事を除く）は最後にWebhookと購読者にも送る。
+func postToMastodon(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, batches []sourceBatch, detector *nearDuplicateDetector) ([]PostedURL, error) {
+	var articles []PostedURL
+	for _, batch := range batches {
+		articles = append(articles, batch.articles...)
+	}
+	if err := reservePostedURLs(ctx, store, config, articles); err != nil {
+		return nil, fmt.Errorf("failed to reserve articles: %w", err)
 	}
 
-	var newPostedURLs []PostedURL
-	for _, article := range allArticles {
-		if _, exists := existingURLMap[article.URL]; !exists {
-			newPostedURLs = append(newPostedURLs, *article)
+	var posted, failed []PostedURL
+	for _, batch := range batches {
+		batchPosted, batchFailed := postArticlesBySource(ctx, store, config, client, batch.source, batch.articles, detector)
+		posted = append(posted, batchPosted...)
+		failed = append(failed, batchFailed...)
+	}
+	if len(failed) > 0 {
+		if err := removePostedURLs(ctx, store, config, failed); err != nil {
+			return nil, fmt.Errorf("failed to release reservations of failed posts: %w", err)
 		}
 	}
 
-	sort.Slice(newPostedURLs, func(i, j int) bool {
-		return newPostedURLs[i].PublishedAt.Before(newPostedURLs[j].PublishedAt)
-	})
-
-	return newPostedURLs, nil
+	sendWebhooks(ctx, config, posted)
+	notifySubscribers(ctx, store, config, client, posted)
+	return failed, nil
 }
 
-func processRSSNews(existingURLMap map[string]struct{}, rssConfig *RSSConfig) ([]PostedURL, error) {
-	fp := gofeed.NewParser()
-	var allArticles []PostedURL
-	for _, rssURL := range rssConfig.RSSSources {
-		feed, err := fp.ParseURL(rssURL)
-		if err != nil {
-			log.Printf("Failed to fetch RSS from %s: %v", rssURL, err)
-			continue
-		}
-
-		for _, item := range feed.Items {
-			if item.Link == "" {
-				continue
+// postArticlesBySource は記事を順に投稿し、単独で投稿した記事と投稿に失敗した記事を返す
+func postArticlesBySource(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, source Source, articles []PostedURL, detector *nearDuplicateDetector) ([]PostedURL, []PostedURL) {
+	var standalone, failed []PostedURL
+	for _, article := range articles {
+		statusID := ""
+		posted := false
+		if article.DuplicateOf != "" {
+			status, handled, err := postNearDuplicate(ctx, config, client, detector, &article)
+			if err != nil {
+				log.Printf("Failed to post near-duplicate article '%s': %v", article.Title, err)
+			} else if handled {
+				posted = true
+				if status != nil {
+					statusID = string(status.ID)
+				}
+			} else {
+				log.Printf("Original of near-duplicate '%s' has no status, posting it on its own", article.Title)
+				article.DuplicateOf = ""
 			}
-
-			if _, exists := existingURLMap[item.Link]; exists {
-				continue
+		}
+		if article.DuplicateOf == "" {
+			statusID = postSingleArticle(ctx, config, client, source, &article)
+			posted = statusID != ""
+			if posted {
+				detector.recordStatus(article.URL, statusID)
+				article.Location = postedLocation(source.FormatPost(&article))
 			}
+		}
 
-			var description string
-			if item.Description != "" {
-				description = item.Description
-				doc, err := goquery.NewDocumentFromReader(strings.NewReader(description))
-				if err == nil {
-					description = doc.Text()
-				}
-				description = "\n\n🔗 " + strings.TrimSpace(description) + "…"
+		if !posted {
+			failed = append(failed, article)
+		} else {
+			article.PostedAt = time.Now()
+			article.Status = ""
+			article.StatusID = statusID
+			if err := savePostedURLs(ctx, store, config, []PostedURL{article}); err != nil {
+				log.Printf("Failed to record posted article '%s', it will be reconciled on the next run: %v", article.Title, err)
 			}
-			if !isBearRelatedNews(item.Title, description, rssConfig) {
-				continue
+			if err := appendArchiveRecords(ctx, store, []ArchiveRecord{newArchiveRecord(article)}); err != nil {
+				log.Printf("Failed to archive posted article '%s': %v", article.Title, err)
 			}
-
-			article := PostedURL{
-				URL:         item.Link,
-				Title:       item.Title,
-				Description: description,
-				PublishedAt: *item.PublishedParsed,
+			if article.DuplicateOf == "" {
+				standalone = append(standalone, article)
 			}
-
-			allArticles = append(allArticles, article)
-			existingURLMap[item.Link] = struct{}{}
 		}
+
+		time.Sleep(PostDelay)
 	}
+	return standalone, failed
+}
 
-	sort.Slice(allArticles, func(i, j int) bool {
-		return allArticles[i].PublishedAt.Before(allArticles[j].PublishedAt)
+// savePostedURLs は保存先の最新の投稿済みURLに新規分をマージして書き込む
+func savePostedURLs(ctx context.Context, store StateStore, appConfig *Config, newlyPosted []PostedURL) error {
+	return updatePostedURLs(ctx, store, appConfig, func(current []PostedURL) []PostedURL {
+		return mergePostedURLs(current, newlyPosted)
 	})
-
-	return allArticles, nil
 }
 
-func isBearRelatedNews(title, description string, rssConfig *RSSConfig) bool {
-	text := title + " " + description
+func removePostedURLs(ctx context.Context, store StateStore, appConfig *Config, removed []PostedURL) error {
+	return updatePostedURLs(ctx, store, appConfig, func(current []PostedURL) []PostedURL {
+		return excludePostedURLs(current, removed)
+	})
+}
 
-	for _, keyword := range rssConfig.ExcludeKeywords {
-		if strings.Contains(text, keyword) {
-			return false
-		}
+// updatePostedURLs は保存先の最新の投稿済みURLを読み込んでupdateを適用し、条件付きで書き込む。
+// 他の実行と競合した場合は読み直してupdateからやり直す。
+func updatePostedURLs(ctx context.Context, store StateStore, appConfig *Config, update func([]PostedURL) []PostedURL) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would update posted URLs in state store")
+		return nil
 	}
 
-	for _, keyword := range rssConfig.IncludeKeywords {
-		if strings.Contains(text, keyword) {
-			return true
+	key := appConfig.AWS.S3.ObjectKey
+	for attempt := 1; attempt <= SaveMaxAttempts; attempt++ {
+		var current []PostedURL
+		version, err := loadJSONVersioned(ctx, store, key, &current)
+		if err != nil && !errors.Is(err, ErrStateNotFound) {
+			return fmt.Errorf("failed to reload posted URLs: %w", err)
 		}
-	}
-
-	return false
-}
 
-func postToMastodon(ctx context.Context, config *Config, client *mastodon.Client, kumaArticles []PostedURL, rssArticles []PostedURL) []PostedURL {
-	return append(postArticlesByType(ctx, config, client, kumaArticles, false), postArticlesByType(ctx, config, client, rssArticles, true)...)
-}
-
-func postArticlesByType(ctx context.Context, config *Config, client *mastodon.Client, articles []PostedURL, isRss bool) []PostedURL {
-	var successfullyPosted []PostedURL
-	for _, article := range articles {
-		success := postSingleArticle(ctx, config, client, &article, isRss)
-		if success {
-			article.PostedAt = time.Now()
-			successfullyPosted = append(successfullyPosted, article)
+		updated := cleanupOldURLs(update(current))
+		if _, err := saveJSONIf(ctx, store, key, updated, version); err == nil {
+			return nil
+		} else if !errors.Is(err, ErrPreconditionFailed) {
+			return fmt.Errorf("failed to save posted URLs: %w", err)
 		}
 
-		time.Sleep(PostDelay)
+		log.Printf("Posted URLs were modified concurrently, retrying save (attempt %d/%d)", attempt, SaveMaxAttempts)
+		time.Sleep(time.Duration(attempt) * SaveRetryDelay)
 	}
-	return successfullyPosted
-}
 
-func savePostedURLs(ctx context.Context, appConfig *Config, postedURLs []PostedURL) error {
-	if os.Getenv("DRY_RUN") == "1" {
-		log.Printf("DRY RUN: Would save %d URLs to S3", len(postedURLs))
-		return nil
-	}
-
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appConfig.AWS.Region))
-	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
-	}
-
-	svc := s3.NewFromConfig(cfg)
+	return fmt.Errorf("failed to save posted URLs after %d attempts: %w", SaveMaxAttempts, ErrPreconditionFailed)
+}
 
-	data, err := json.MarshalIndent(postedURLs, "", "    ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal posted URLs: %w", err)
+func mergePostedURLs(current, updates []PostedURL) []PostedURL {
+	indexByURL := make(map[string]int, len(current))
+	merged := make([]PostedURL, 0, len(current)+len(updates))
+	for _, posted := range current {
+		indexByURL[posted.URL] = len(merged)
+		merged = append(merged, posted)
 	}
 
-	contentType := "application/json"
-	_, err = svc.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(appConfig.AWS.S3.BucketName),
-		Key:         aws.String(appConfig.AWS.S3.ObjectKey),
-		Body:        bytes.NewReader(data),
-		ContentType: &contentType,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to put object to S3: %w", err)
+	for _, posted := range updates {
+		if i, exists := indexByURL[posted.URL]; exists {
+			merged[i] = posted
+			continue
+		}
+		indexByURL[posted.URL] = len(merged)
+		merged = append(merged, posted)
 	}
 
-	return nil
+	return merged
 }
 
 func fetchRecentToots(ctx context.Context, client *mastodon.Client, since time.Time) ([]*mastodon.Status, error) {
@@ -537,169 +725,53 @@ func fetchRecentToots(ctx context.Context, client *mastodon.Client, since time.T
 	return allToots, nil
 }
 
-func aggregatePrefectures(toots []*mastodon.Status) ([]PrefectureCount, int) {
-	prefectureCountMap := make(map[string]int)
-	prefectureRegex := regexp.MustCompile(prefecturePattern)
-	var totalCount int
-	var otherCount int
-	for _, toot := range toots {
-		matches := prefectureRegex.FindStringSubmatch(toot.Content)
-		if len(matches) > 1 {
-			location := strings.TrimSpace(matches[1])
-
-			prefecture := extractPrefecture(location)
-			if prefecture != "" {
-				prefectureCountMap[prefecture]++
-			} else {
-				otherCount++
-			}
-			totalCount++
-		}
-	}
-
-	var results []PrefectureCount
-	for prefecture, count := range prefectureCountMap {
-		results = append(results, PrefectureCount{
-			Prefecture: prefecture,
-			Count:      count,
-		})
-	}
-
-	sort.Slice(results, func(i, j int) bool {
-		if results[i].Count == results[j].Count {
-			return results[i].Prefecture < results[j].Prefecture
-		}
-		return results[i].Count > results[j].Count
-	})
-
-	if otherCount > 0 {
-		results = append(results, PrefectureCount{
-			Prefecture: OtherPrefecture,
-			Count:      otherCount,
-		})
-	}
-
-	return results, totalCount
-}
-
-func postPrefectureSummary(ctx context.Context, config *Config, client *mastodon.Client, toots []*mastodon.Status, date time.Time) error {
-	prefectureStats, totalPosts := aggregatePrefectures(toots)
+func postPrefectureSummary(ctx context.Context, config *Config, client *mastodon.Client, prefectureStats []PrefectureCount, totalPosts int, date time.Time) error {
 	dateStr := date.Format("2006年1月2日")
 	postContent := fmt.Sprintf(SummaryPostTemplate, dateStr, totalPosts, formatPrefectureStats(prefectureStats))
 
-	status, err := postToMastodonWithContent(ctx, config, client, postContent)
-	if err != nil {
-		return fmt.Errorf("failed to post prefecture summary: %w", err)
-	}
-
-	if err := pinSummaryPosts(ctx, client, status.ID); err != nil {
-		log.Printf("Failed to pin summary post: %v", err)
-	}
-
-	return nil
-}
-
-func fetchHTML(page int) (*goquery.Document, error) {
-	client := &http.Client{Timeout: HTTPTimeout}
-	url := fmt.Sprintf("%s?page=%d", KumaNewsURL, page)
-
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch page %d from %s: %w", page, KumaNewsURL, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("page %d not found (404)", page)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error %d when fetching page %d", resp.StatusCode, page)
+	var charts []chartAttachment
+	if chart, err := renderRankingChart("Bear sightings by prefecture, "+date.Format("2006-01-02"), prefectureStats); err != nil {
+		log.Printf("Failed to render ranking chart: %v", err)
+	} else {
+		charts = append(charts, chartAttachment{Name: "ranking", PNG: chart, Description: rankingChartDescription(dateStr, prefectureStats)})
 	}
-
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML from page %d: %w", page, err)
+	if chart, err := renderPrefectureMap("Bear sightings map, "+date.Format("2006-01-02"), prefectureStats); err != nil {
+		log.Printf("Failed to render prefecture map: %v", err)
+	} else {
+		charts = append(charts, chartAttachment{Name: "map", PNG: chart, Description: prefectureMapDescription(dateStr, prefectureStats)})
 	}
 
-	return doc, nil
-}
-
-func parseArticles(doc *goquery.Document, page int) []*PostedURL {
-	var articles []*PostedURL
-
-	doc.Find("li.h-bm02").Each(func(i int, s *goquery.Selection) {
-		if s.Find("div[data-allox-placement]").Length() > 0 {
-			return
-		}
-
-		article := extractArticleInfo(s, page)
-		if article != nil {
-			articles = append(articles, article)
-		}
-	})
-
-	return articles
-}
-
-func extractArticleInfo(s *goquery.Selection, page int) *PostedURL {
-	thumbsListUnit := s.Find("div.thumbsListUnit")
-	newsListSupplement := thumbsListUnit.Find("p.newsListSupplement")
-	dateText := strings.TrimSpace(newsListSupplement.Find("span.newsDate").Text())
-	timeText := strings.TrimSpace(newsListSupplement.Find("span.newsTime").Text())
-
-	timestamp, err := parseDateTime(dateText, timeText)
-	if err != nil {
-		log.Printf("Skipping article on page %d due to datetime parse error: %v", page, err)
-		return nil
+	if _, err := publish(ctx, config, client, &Publication{Text: postContent, Charts: charts}, true); err != nil {
+		return fmt.Errorf("failed to post prefecture summary: %w", err)
 	}
 
-	title := strings.TrimSpace(thumbsListUnit.Find("h3.thumbsListTitle").Text())
-	href, _ := thumbsListUnit.Find("h3.thumbsListTitle").Closest("a").Attr("href")
-	source := strings.TrimSpace(newsListSupplement.Find("span.newsTenter").Text())
-	region := strings.TrimSpace(s.Find("ul.topics-keywords li a").Text())
-
-	return &PostedURL{
-		Title:       title,
-		URL:         href,
-		Description: fmt.Sprintf("%s %s %s %s", region, source, dateText, timeText),
-		PublishedAt: timestamp,
-	}
+	return nil
 }
 
-func postSingleArticle(ctx context.Context, config *Config, client *mastodon.Client, article *PostedURL, isRss bool) bool {
-	var post string
-	if isRss {
-		post = fmt.Sprintf(RSSNewsTemplate, article.Title, article.URL, article.Description)
-		if len([]rune(post)) > 500 {
-			post = fmt.Sprintf(RSSNewsTemplate, article.Title, article.URL, "")
-		}
-	} else {
-		post = fmt.Sprintf(KumaPostTemplate, article.Title, article.URL, article.Description)
-	}
+// postSingleArticle は記事を投稿し、Mastodonの投稿IDを返す。失敗した場合は空文字列を返す。
+func postSingleArticle(ctx context.Context, config *Config, client *mastodon.Client, source Source, article *PostedURL) string {
+	post := source.FormatPost(article)
 
-	_, err := postToMastodonWithContent(ctx, config, client, post)
+	statusID, err := publish(withIdempotencyKey(ctx, article.URL), config, client, &Publication{Text: post, Article: article}, false)
 	if err != nil {
 		log.Printf("Failed to post article '%s': %v", article.Title, err)
-		return false
+		return ""
 	}
 
-	return true
+	return statusID
 }
 
-func postToMastodonWithContent(ctx context.Context, config *Config, client *mastodon.Client, content string) (*mastodon.Status, error) {
-	if os.Getenv("DRY_RUN") == "1" {
-		log.Printf("DRY RUN: Would post to Mastodon:\n%s", content)
+func postTootToMastodon(ctx context.Context, config *Config, client *mastodon.Client, toot *mastodon.Toot) (*mastodon.Status, error) {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would post to Mastodon:\n%s", toot.Status)
 		return &mastodon.Status{ID: mastodon.ID("dry-run")}, nil
 	}
 
-	log.Printf("Post to Mastodon:\n%s", content)
-	status, err := client.PostStatus(ctx, &mastodon.Toot{
-		Status:     content,
-		Visibility: config.Mastodon.Visibility,
-	})
+	toot.Visibility = config.Mastodon.Visibility
+	log.Printf("Post to Mastodon:\n%s", toot.Status)
+	status, err := client.PostStatus(ctx, toot)
 	if err != nil {
-		log.Printf("Failed to post content to Mastodon:\n%s\nError: %v", content, err)
+		log.Printf("Failed to post content to Mastodon:\n%s\nError: %v", toot.Status, err)
 		return nil, fmt.Errorf("failed to post to Mastodon: %w", err)
 	}
 
@@ -762,13 +834,29 @@ func pinSummaryPosts(ctx context.Context, client *mastodon.Client, newStatusID m
 }
 
 func extractPrefecture(text string) string {
+	if found := extractPrefectures(text); len(found) > 0 {
+		return found[0]
+	}
+
+	return ""
+}
+
+func extractPrefectures(text string) []string {
+	var found []string
 	for _, prefecture := range prefectures {
 		if strings.Contains(text, prefecture) {
-			return prefecture
+			found = append(found, prefecture)
 		}
 	}
 
-	return ""
+	// 都道府県名がない場合は市区町村名から都道府県を引く
+	if len(found) == 0 {
+		if municipality, ok := loadGazetteer().locate(text); ok {
+			found = append(found, municipality.Prefecture)
+		}
+	}
+
+	return found
 }
 
 func formatPrefectureStats(stats []PrefectureCount) string {
@@ -789,29 +877,3 @@ func formatPrefectureStats(stats []PrefectureCount) string {
 	}
 	return strings.Join(lines, "\n")
 }
-
-func parseDateTime(dateText, timeText string) (time.Time, error) {
-	jst := time.FixedZone("JST", JSTOffset)
-	nowJST := time.Now().In(jst)
-
-	if idx := strings.Index(dateText, "("); idx > 0 {
-		dateText = dateText[:idx]
-	}
-
-	dateTimeStr := fmt.Sprintf("%d/%s %s", nowJST.Year(), dateText, timeText)
-
-	parsedTime, err := time.ParseInLocation("2006/1/2 15:4", dateTimeStr, jst)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to parse datetime '%s %s': %v", dateText, timeText, err)
-	}
-
-	if parsedTime.After(nowJST) {
-		dateTimeStr = fmt.Sprintf("%d/%s %s", nowJST.Year()-1, dateText, timeText)
-		parsedTime, err = time.ParseInLocation("2006/1/2 15:4", dateTimeStr, jst)
-		if err != nil {
-			return time.Time{}, fmt.Errorf("failed to parse previous year datetime '%s': %v", dateTimeStr, err)
-		}
-	}
-
-	return parsedTime, nil
-}
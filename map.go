@@ -0,0 +1,281 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+//go:embed data/japan_tiles.tsv
+var japanTileData string
+
+const (
+	mapColumns      = 16
+	mapRows         = 18
+	mapCellSize     = 47
+	mapClassCount   = 5
+	mapLegendSwatch = 18
+)
+
+var (
+	mapEmpty  = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	mapBorder = color.RGBA{0x66, 0x66, 0x66, 0xff}
+	// 件数の少ない順の塗り色。後ろ2段は文字を白にする
+	mapShades = [mapClassCount]color.RGBA{
+		{0xf3, 0xe3, 0xcf, 0xff},
+		{0xe2, 0xbf, 0x94, 0xff},
+		{0xc8, 0x95, 0x5e, 0xff},
+		{0xa8, 0x70, 0x3c, 0xff},
+		{0x7a, 0x4a, 0x1e, 0xff},
+	}
+)
+
+// prefectureTile は塗り分け地図上の都道府県の形（マスの集まり）
+type prefectureTile struct {
+	Prefecture string
+	Label      string
+	Cells      []image.Point
+}
+
+// mapClass は塗り分けの1段階と、その色になる件数の範囲
+type mapClass struct {
+	Min   int
+	Max   int
+	Color color.RGBA
+}
+
+var (
+	japanTiles     []prefectureTile
+	japanTilesOnce sync.Once
+)
+
+func loadJapanTiles() []prefectureTile {
+	japanTilesOnce.Do(func() {
+		japanTiles = parseJapanTiles(japanTileData)
+	})
+	return japanTiles
+}
+
+func parseJapanTiles(data string) []prefectureTile {
+	var tiles []prefectureTile
+	occupied := make(map[image.Point]string)
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			log.Printf("Skipping malformed map tile line %d: %q", i+1, line)
+			continue
+		}
+
+		tile := prefectureTile{Prefecture: fields[0], Label: fields[1]}
+		for _, cell := range strings.Fields(fields[2]) {
+			point, ok := parseTileCell(cell)
+			if !ok {
+				log.Printf("Skipping invalid map cell %q of %s", cell, tile.Prefecture)
+				continue
+			}
+			if owner, exists := occupied[point]; exists {
+				log.Printf("Skipping map cell %q of %s already used by %s", cell, tile.Prefecture, owner)
+				continue
+			}
+			occupied[point] = tile.Prefecture
+			tile.Cells = append(tile.Cells, point)
+		}
+		if len(tile.Cells) > 0 {
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles
+}
+
+func parseTileCell(cell string) (image.Point, bool) {
+	col, row, found := strings.Cut(cell, ",")
+	if !found {
+		return image.Point{}, false
+	}
+	x, xErr := strconv.Atoi(col)
+	y, yErr := strconv.Atoi(row)
+	if xErr != nil || yErr != nil || x < 0 || x >= mapColumns || y < 0 || y >= mapRows {
+		return image.Point{}, false
+	}
+	return image.Pt(x, y), true
+}
+
+// labelCell は都道府県名と件数を書くマス（形の重心に最も近いマス）を返す
+func (t prefectureTile) labelCell() image.Point {
+	var sumX, sumY int
+	for _, cell := range t.Cells {
+		sumX += cell.X
+		sumY += cell.Y
+	}
+
+	best := t.Cells[0]
+	bestDistance := -1
+	for _, cell := range t.Cells {
+		dx := cell.X*len(t.Cells) - sumX
+		dy := cell.Y*len(t.Cells) - sumY
+		if distance := dx*dx + dy*dy; bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = cell, distance
+		}
+	}
+	return best
+}
+
+// mapClasses は最大件数をmapClassCount段階に等分した塗り分けの段階を返す。件数のない段階は省く。
+func mapClasses(maxCount int) []mapClass {
+	var classes []mapClass
+	for i := range mapClassCount {
+		lower := i*maxCount/mapClassCount + 1
+		upper := (i + 1) * maxCount / mapClassCount
+		if lower <= upper {
+			classes = append(classes, mapClass{Min: lower, Max: upper, Color: mapShades[i]})
+		}
+	}
+	return classes
+}
+
+// mapShade は件数の塗り色と、その上に書く文字の色を返す
+func mapShade(count, maxCount int) (color.RGBA, color.RGBA) {
+	if count <= 0 || maxCount <= 0 {
+		return mapEmpty, chartText
+	}
+	class := min((count*mapClassCount+maxCount-1)/maxCount-1, mapClassCount-1)
+	if class >= mapClassCount-2 {
+		return mapShades[class], chartBackground
+	}
+	return mapShades[class], chartText
+}
+
+// renderPrefectureMap は都道府県ごとの件数を色の濃さで塗り分けた日本地図を描く
+func renderPrefectureMap(title string, stats []PrefectureCount) ([]byte, error) {
+	fonts, err := loadChartFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(stats))
+	maxCount := 0
+	for _, stat := range stats {
+		counts[stat.Prefecture] = stat.Count
+		if stat.Prefecture != OtherPrefecture {
+			maxCount = max(maxCount, stat.Count)
+		}
+	}
+
+	left := (ChartWidth - mapColumns*mapCellSize) / 2
+	top := chartTitleHeight
+	canvas := newChartCanvas(top + mapRows*mapCellSize + chartMargin)
+	drawText(canvas, fonts.bold, chartMargin, chartMargin+chartTitleFontSize/2, title)
+
+	cellRect := func(cell image.Point) image.Rectangle {
+		x, y := left+cell.X*mapCellSize, top+cell.Y*mapCellSize
+		return image.Rect(x, y, x+mapCellSize, y+mapCellSize)
+	}
+
+	owners := make(map[image.Point]string)
+	for _, tile := range loadJapanTiles() {
+		fill, _ := mapShade(counts[tile.Prefecture], maxCount)
+		for _, cell := range tile.Cells {
+			owners[cell] = tile.Prefecture
+			fillRect(canvas, cellRect(cell), fill)
+		}
+	}
+
+	// 同じ都道府県のマスの間には線を引かず、境界と海岸線だけを描く
+	for cell, owner := range owners {
+		rect := cellRect(cell)
+		if owners[cell.Add(image.Pt(0, -1))] != owner {
+			fillRect(canvas, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X+1, rect.Min.Y+1), mapBorder)
+		}
+		if owners[cell.Add(image.Pt(0, 1))] != owner {
+			fillRect(canvas, image.Rect(rect.Min.X, rect.Max.Y, rect.Max.X+1, rect.Max.Y+1), mapBorder)
+		}
+		if owners[cell.Add(image.Pt(-1, 0))] != owner {
+			fillRect(canvas, image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+1, rect.Max.Y+1), mapBorder)
+		}
+		if owners[cell.Add(image.Pt(1, 0))] != owner {
+			fillRect(canvas, image.Rect(rect.Max.X, rect.Min.Y, rect.Max.X+1, rect.Max.Y+1), mapBorder)
+		}
+	}
+
+	for _, tile := range loadJapanTiles() {
+		count := counts[tile.Prefecture]
+		_, textColor := mapShade(count, maxCount)
+		rect := cellRect(tile.labelCell())
+		centerX := (rect.Min.X + rect.Max.X) / 2
+		drawTextCentered(canvas, fonts.regular, centerX, rect.Min.Y+chartFontSize+4, tile.Label, textColor)
+		if count > 0 {
+			drawTextCentered(canvas, fonts.bold, centerX, rect.Max.Y-8, strconv.Itoa(count), textColor)
+		}
+	}
+
+	drawMapLegend(canvas, fonts, chartMargin, top+chartMargin, maxCount, counts[OtherPrefecture])
+
+	return encodePNG(canvas)
+}
+
+// drawMapLegend は地図の左上の海の部分に凡例を描く
+func drawMapLegend(canvas *image.RGBA, fonts *chartFonts, x, y, maxCount, otherCount int) {
+	row := 0
+	legendRow := func(swatch color.RGBA, label string) {
+		top := y + row*(mapLegendSwatch+6)
+		fillRect(canvas, image.Rect(x, top, x+mapLegendSwatch, top+mapLegendSwatch), mapBorder)
+		fillRect(canvas, image.Rect(x+1, top+1, x+mapLegendSwatch-1, top+mapLegendSwatch-1), swatch)
+		drawText(canvas, fonts.regular, x+mapLegendSwatch+8, top+mapLegendSwatch-4, label)
+		row++
+	}
+
+	legendRow(mapEmpty, "0")
+	for _, class := range mapClasses(maxCount) {
+		if class.Min == class.Max {
+			legendRow(class.Color, strconv.Itoa(class.Min))
+		} else {
+			legendRow(class.Color, fmt.Sprintf("%d-%d", class.Min, class.Max))
+		}
+	}
+	if otherCount > 0 {
+		drawText(canvas, fonts.regular, x, y+row*(mapLegendSwatch+6)+chartFontSize+4, fmt.Sprintf("Unknown prefecture: %d", otherCount))
+	}
+}
+
+func drawTextCentered(canvas *image.RGBA, face font.Face, centerX, y int, text string, c color.Color) {
+	width := font.MeasureString(face, text).Ceil()
+	drawer := &font.Drawer{Dst: canvas, Src: image.NewUniform(c), Face: face, Dot: fixed.P(centerX-width/2, y)}
+	drawer.DrawString(text)
+}
+
+// prefectureMapDescription は塗り分け地図の代替テキストを返す
+func prefectureMapDescription(heading string, stats []PrefectureCount) string {
+	var parts []string
+	otherCount := 0
+	for _, stat := range stats {
+		if stat.Prefecture == OtherPrefecture {
+			otherCount = stat.Count
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %d件", stat.Prefecture, stat.Count))
+	}
+
+	description := heading + "の都道府県別の件数を色の濃さで塗り分けた日本地図。"
+	if len(parts) == 0 {
+		description += "出没情報があった都道府県はありません。"
+	} else {
+		description += fmt.Sprintf("出没情報があった%d都道府県: %s。", len(parts), strings.Join(parts, "、"))
+	}
+	if otherCount > 0 {
+		description += fmt.Sprintf("都道府県不明 %d件。", otherCount)
+	}
+	return truncateMediaDescription(description)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// MisskeyMaxFileComment はドライブのファイルのキャプション（代替テキスト）の上限文字数
+	MisskeyMaxFileComment = 512
+	// misskeyPinLimitExceeded はピン留めの上限（サーバーのロールごとに異なる）に達した場合のエラーコード
+	misskeyPinLimitExceeded = "PIN_LIMIT_EXCEEDED"
+	// misskeySummaryMarker は日別・週間・月間集計の本文に共通する語。ピン留めを外すのは集計のノートに限る。
+	misskeySummaryMarker = "クマ出没情報集計"
+)
+
+type MisskeyConfig struct {
+	Server      string `json:"server"`
+	AccessToken string `json:"access_token"`
+}
+
+// misskeyAPIError はMisskey APIが返したエラー
+type misskeyAPIError struct {
+	endpoint   string
+	statusCode int
+	code       string
+	message    string
+}
+
+func (e *misskeyAPIError) Error() string {
+	return fmt.Sprintf("%s returned status code %d: %s (%s)", e.endpoint, e.statusCode, e.message, e.code)
+}
+
+type misskeyPublisher struct {
+	server      string
+	accessToken string
+	visibility  string
+	httpClient  *http.Client
+}
+
+func newMisskeyPublisher(config *Config) *misskeyPublisher {
+	return &misskeyPublisher{
+		server:      strings.TrimSuffix(config.Misskey.Server, "/"),
+		accessToken: config.Misskey.AccessToken,
+		visibility:  misskeyVisibility(config.Mastodon.Visibility),
+		httpClient:  &http.Client{Timeout: HTTPTimeout},
+	}
+}
+
+// misskeyVisibility はMastodonの公開範囲を対応するMisskeyの公開範囲に変換する。空の場合はサーバーの既定に従う。
+func misskeyVisibility(visibility string) string {
+	switch visibility {
+	case "public":
+		return "public"
+	case "unlisted":
+		return "home"
+	case "private":
+		return "followers"
+	case "direct":
+		return "specified"
+	default:
+		return ""
+	}
+}
+
+func (p *misskeyPublisher) Name() string {
+	return "misskey"
+}
+
+func (p *misskeyPublisher) Publish(ctx context.Context, post *Publication) (string, error) {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would post to Misskey (visibility: %s) with %d charts", p.visibility, len(post.Charts))
+		return "dry-run", nil
+	}
+
+	params := map[string]any{"text": post.Text}
+	if p.visibility != "" {
+		params["visibility"] = p.visibility
+	}
+	var fileIDs []string
+	for _, chart := range post.Charts {
+		fileID, err := p.uploadChart(ctx, chart)
+		if err != nil {
+			log.Printf("Failed to upload chart '%s' to Misskey: %v", chart.Name, err)
+			continue
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	if len(fileIDs) > 0 {
+		params["fileIds"] = fileIDs
+	}
+
+	var result struct {
+		CreatedNote struct {
+			ID string `json:"id"`
+		} `json:"createdNote"`
+	}
+	if err := p.call(ctx, "notes/create", params, &result); err != nil {
+		return "", fmt.Errorf("failed to post to Misskey: %w", err)
+	}
+	log.Printf("Posted to Misskey: %s", result.CreatedNote.ID)
+	return result.CreatedNote.ID, nil
+}
+
+// Pin はノートをピン留めする。上限に達している場合は、ピン留め中の集計のうち最も古いノートのピン留めを外してから再度ピン留めする。
+func (p *misskeyPublisher) Pin(ctx context.Context, id string) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would pin Misskey note %s", id)
+		return nil
+	}
+
+	err := p.call(ctx, "i/pin", map[string]any{"noteId": id}, nil)
+	var apiErr *misskeyAPIError
+	if err == nil || !errors.As(err, &apiErr) || apiErr.code != misskeyPinLimitExceeded {
+		if err != nil {
+			return fmt.Errorf("failed to pin note: %w", err)
+		}
+		return nil
+	}
+
+	oldest, err := p.oldestPinnedSummary(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.call(ctx, "i/unpin", map[string]any{"noteId": oldest}, nil); err != nil {
+		return fmt.Errorf("failed to unpin oldest summary note: %w", err)
+	}
+	if err := p.call(ctx, "i/pin", map[string]any{"noteId": id}, nil); err != nil {
+		return fmt.Errorf("failed to pin note: %w", err)
+	}
+	return nil
+}
+
+// oldestPinnedSummary はピン留め中の集計のノートのうち、作成日時が最も古いノートのIDを返す
+func (p *misskeyPublisher) oldestPinnedSummary(ctx context.Context) (string, error) {
+	var account struct {
+		PinnedNotes []struct {
+			ID        string    `json:"id"`
+			Text      string    `json:"text"`
+			CreatedAt time.Time `json:"createdAt"`
+		} `json:"pinnedNotes"`
+	}
+	if err := p.call(ctx, "i", map[string]any{}, &account); err != nil {
+		return "", fmt.Errorf("failed to get pinned notes: %w", err)
+	}
+
+	var oldestID string
+	var oldestAt time.Time
+	for _, note := range account.PinnedNotes {
+		if !strings.Contains(note.Text, misskeySummaryMarker) {
+			continue
+		}
+		if oldestID == "" || note.CreatedAt.Before(oldestAt) {
+			oldestID, oldestAt = note.ID, note.CreatedAt
+		}
+	}
+	if oldestID == "" {
+		return "", fmt.Errorf("pin limit reached and no pinned summary note to unpin")
+	}
+	return oldestID, nil
+}
+
+// uploadChart は画像をドライブにアップロードしてファイルIDを返す
+func (p *misskeyPublisher) uploadChart(ctx context.Context, chart chartAttachment) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("i", p.accessToken)
+	writer.WriteField("name", chart.Name+".png")
+	writer.WriteField("comment", truncateRunes(chart.Description, MisskeyMaxFileComment))
+	part, err := writer.CreateFormFile("file", chart.Name+".png")
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(chart.PNG); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(ctx, "drive/files/create", writer.FormDataContentType(), &body, &file); err != nil {
+		return "", err
+	}
+	return file.ID, nil
+}
+
+// call はMisskey APIのエンドポイントにアクセストークンを含むJSONを送り、応答をresultに読み込む
+func (p *misskeyPublisher) call(ctx context.Context, endpoint string, params map[string]any, result any) error {
+	params["i"] = p.accessToken
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", endpoint, err)
+	}
+	return p.do(ctx, endpoint, "application/json", bytes.NewReader(payload), result)
+}
+
+func (p *misskeyPublisher) do(ctx context.Context, endpoint, contentType string, body io.Reader, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.server+"/api/"+endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", endpoint, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiError) == nil && apiError.Error.Code != "" {
+			return &misskeyAPIError{endpoint: endpoint, statusCode: resp.StatusCode, code: apiError.Error.Code, message: apiError.Error.Message}
+		}
+		return fmt.Errorf("%s returned status code: %d", endpoint, resp.StatusCode)
+	}
+
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-mastodon"
+	"golang.org/x/text/width"
+)
+
+const (
+	NearDuplicateModeOff      = "off"
+	NearDuplicateModeSuppress = "suppress"
+	NearDuplicateModeReply    = "reply"
+
+	DefaultNearDuplicateThreshold   = 0.5
+	DefaultNearDuplicateWindowHours = 48
+	similarityDescriptionRunes      = 120
+
+	OtherCoverageTemplate = `📰 ほかの報道：%s
+
+%s`
+)
+
+// NearDuplicateConfig は別の取得元から届いた同じ出来事の記事をまとめるための設定
+type NearDuplicateConfig struct {
+	Mode        string  `json:"mode"`
+	Threshold   float64 `json:"threshold"`
+	WindowHours int     `json:"window_hours"`
+}
+
+type similarityCandidate struct {
+	url         string
+	prefecture  string
+	publishedAt time.Time
+	title       map[string]struct{}
+	text        map[string]struct{}
+}
+
+// nearDuplicateDetector はタイトルと概要の文字バイグラムのJaccard係数で、時間枠内の既出記事と似た記事を検出する
+type nearDuplicateDetector struct {
+	config     NearDuplicateConfig
+	candidates []similarityCandidate
+	statusIDs  map[string]string
+}
+
+func newNearDuplicateDetector(config NearDuplicateConfig, recent []PostedURL) *nearDuplicateDetector {
+	if config.Mode == "" {
+		config.Mode = NearDuplicateModeOff
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultNearDuplicateThreshold
+	}
+	if config.WindowHours <= 0 {
+		config.WindowHours = DefaultNearDuplicateWindowHours
+	}
+
+	detector := &nearDuplicateDetector{
+		config:    config,
+		statusIDs: make(map[string]string),
+	}
+
+	cutoffTime := time.Now().Add(-time.Duration(config.WindowHours) * time.Hour)
+	for _, posted := range recent {
+		if posted.DuplicateOf != "" || posted.PostedAt.Before(cutoffTime) {
+			continue
+		}
+		detector.add(posted)
+		if posted.StatusID != "" {
+			detector.statusIDs[posted.URL] = posted.StatusID
+		}
+	}
+
+	return detector
+}
+
+func (d *nearDuplicateDetector) enabled() bool {
+	return d.config.Mode == NearDuplicateModeSuppress || d.config.Mode == NearDuplicateModeReply
+}
+
+func (d *nearDuplicateDetector) add(article PostedURL) {
+	d.candidates = append(d.candidates, similarityCandidate{
+		url:         article.URL,
+		prefecture:  article.Prefecture,
+		publishedAt: article.PublishedAt,
+		title:       bigrams(article.Title),
+		text:        bigrams(article.Title + similarityDescription(article.Description)),
+	})
+}
+
+// mark は投稿順に記事を比較し、既出記事と似ている記事のDuplicateOfに元記事のURLを設定する
+func (d *nearDuplicateDetector) mark(batches []sourceBatch) {
+	if !d.enabled() {
+		return
+	}
+
+	window := time.Duration(d.config.WindowHours) * time.Hour
+	for _, batch := range batches {
+		for i := range batch.articles {
+			article := &batch.articles[i]
+			title := bigrams(article.Title)
+			text := bigrams(article.Title + similarityDescription(article.Description))
+
+			for _, candidate := range d.candidates {
+				if article.Prefecture != "" && candidate.prefecture != "" && article.Prefecture != candidate.prefecture {
+					continue
+				}
+				if diff := article.PublishedAt.Sub(candidate.publishedAt); diff > window || diff < -window {
+					continue
+				}
+				if max(jaccard(title, candidate.title), jaccard(text, candidate.text)) >= d.config.Threshold {
+					article.DuplicateOf = candidate.url
+					log.Printf("Detected near-duplicate '%s' of %s", article.Title, candidate.url)
+					break
+				}
+			}
+
+			if article.DuplicateOf == "" {
+				d.add(*article)
+			}
+		}
+	}
+}
+
+func (d *nearDuplicateDetector) recordStatus(articleURL, statusID string) {
+	d.statusIDs[articleURL] = statusID
+}
+
+// postNearDuplicate は重複記事を設定に応じて投稿せずに記録するか、元記事への返信として投稿する。
+// 元記事の投稿IDが分からない場合はhandled=falseを返し、通常の投稿にまかせる。
+func postNearDuplicate(ctx context.Context, config *Config, client *mastodon.Client, detector *nearDuplicateDetector, article *PostedURL) (status *mastodon.Status, handled bool, err error) {
+	switch detector.config.Mode {
+	case NearDuplicateModeSuppress:
+		log.Printf("Suppressing near-duplicate '%s' of %s", article.Title, article.DuplicateOf)
+		return nil, true, nil
+	case NearDuplicateModeReply:
+		replyTo := detector.statusIDs[article.DuplicateOf]
+		if replyTo == "" {
+			return nil, false, nil
+		}
+		content := fmt.Sprintf(OtherCoverageTemplate, article.Title, article.URL)
+		status, err := postTootToMastodon(withIdempotencyKey(ctx, article.URL), config, client, &mastodon.Toot{
+			Status:      content,
+			InReplyToID: mastodon.ID(replyTo),
+		})
+		return status, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+func similarityDescription(description string) string {
+	description = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(description), "🔗"))
+	runes := []rune(description)
+	if len(runes) > similarityDescriptionRunes {
+		runes = runes[:similarityDescriptionRunes]
+	}
+	return string(runes)
+}
+
+// bigrams は全角・半角を揃えて記号と空白を除いた文字列の文字バイグラム集合を返す
+func bigrams(text string) map[string]struct{} {
+	var runes []rune
+	for _, r := range width.Fold.String(strings.ToLower(text)) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			runes = append(runes, r)
+		}
+	}
+
+	set := make(map[string]struct{}, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		set[string(runes[i:i+2])] = struct{}{}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	intersection := 0
+	for gram := range a {
+		if _, ok := b[gram]; ok {
+			intersection++
+		}
+	}
+
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	SummaryPeriodWeekly  = "weekly"
+	SummaryPeriodMonthly = "monthly"
+	MaxPostRunes         = 500
+
+	PeriodicSummaryPostTemplate = `🐻 %sの%sクマ出没情報集計（全%d件、%s比 %s）
+※あくまで出没情報記事数の集計なので実際の出没数とは限りません
+
+📍 都道府県別ランキング（%s比）:
+%s%s
+
+#クマ出没情報`
+)
+
+// summaryPeriod は週間・月間集計の対象期間と比較対象の前期間。Endは含まない。
+type summaryPeriod struct {
+	Kind          string
+	Label         string
+	PreviousLabel string
+	ChartLabel    string
+	Title         string
+	Start         time.Time
+	End           time.Time
+	PreviousStart time.Time
+}
+
+// PrefectureTrend は前期間と比べた都道府県ごとの件数
+type PrefectureTrend struct {
+	Prefecture string
+	Count      int
+	Previous   int
+	New        bool
+}
+
+// weeklyPeriod は月曜日に投稿する前週（月曜〜日曜）の集計期間を返す
+func weeklyPeriod(today time.Time) summaryPeriod {
+	start := today.AddDate(0, 0, -7)
+	last := today.AddDate(0, 0, -1)
+	return summaryPeriod{
+		Kind:          SummaryPeriodWeekly,
+		Label:         "週間",
+		PreviousLabel: "前週",
+		ChartLabel:    "Weekly",
+		Title:         fmt.Sprintf("%s〜%s", start.Format("2006年1月2日"), last.Format("1月2日")),
+		Start:         start,
+		End:           today,
+		PreviousStart: start.AddDate(0, 0, -7),
+	}
+}
+
+// monthlyPeriod は1日に投稿する前月の集計期間を返す
+func monthlyPeriod(today time.Time) summaryPeriod {
+	end := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	start := end.AddDate(0, -1, 0)
+	return summaryPeriod{
+		Kind:          SummaryPeriodMonthly,
+		Label:         "月間",
+		PreviousLabel: "前月",
+		ChartLabel:    "Monthly",
+		Title:         start.Format("2006年1月"),
+		Start:         start,
+		End:           end,
+		PreviousStart: start.AddDate(0, -1, 0),
+	}
+}
+
+// dueSummaryPeriods は今日投稿する週間・月間集計を返す。forceに"weekly" / "monthly" / "all"を指定すると曜日・日付に関係なく対象にする。
+func dueSummaryPeriods(today time.Time, force string) []summaryPeriod {
+	var periods []summaryPeriod
+	if today.Weekday() == time.Monday || force == SummaryPeriodWeekly || force == "all" {
+		periods = append(periods, weeklyPeriod(today))
+	}
+	if today.Day() == 1 || force == SummaryPeriodMonthly || force == "all" {
+		periods = append(periods, monthlyPeriod(today))
+	}
+	return periods
+}
+
+// runPeriodicSummaries はtoday（投稿日のJSTの0時）に投稿する週間・月間集計を投稿する
+func runPeriodicSummaries(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, today time.Time, force string) error {
+	var errs []error
+	for _, period := range dueSummaryPeriods(today, force) {
+		log.Printf("Starting %s summary for %s", period.Kind, period.Title)
+		if err := postPeriodicSummary(ctx, store, config, client, period); err != nil {
+			errs = append(errs, fmt.Errorf("failed to post %s summary: %w", period.Kind, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadArchiveRange は[start, end)の日別アーカイブを読み込む。アーカイブがない日は0件として扱う。
+func loadArchiveRange(ctx context.Context, store StateStore, start, end time.Time) ([]ArchiveRecord, error) {
+	var records []ArchiveRecord
+	for date := start; date.Before(end); date = date.AddDate(0, 0, 1) {
+		dayRecords, err := loadArchiveRecords(ctx, store, date)
+		if errors.Is(err, ErrStateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load archive for %s: %w", date.Format(ArchiveDateFormat), err)
+		}
+		records = append(records, dayRecords...)
+	}
+
+	return records, nil
+}
+
+func postPeriodicSummary(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, period summaryPeriod) error {
+	records, err := loadArchiveRange(ctx, store, period.Start, period.End)
+	if err != nil {
+		return err
+	}
+	previousRecords, err := loadArchiveRange(ctx, store, period.PreviousStart, period.Start)
+	if err != nil {
+		return err
+	}
+
+	stats, total := aggregateArchiveRecords(records)
+	previousStats, previousTotal := aggregateArchiveRecords(previousRecords)
+	trends := comparePrefectureStats(stats, previousStats)
+
+	postContent := formatPeriodicSummary(period, trends, total, previousTotal)
+	post := &Publication{Text: postContent, Charts: periodicSummaryCharts(ctx, store, period, stats)}
+	if _, err := publish(ctx, config, client, post, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// periodicSummaryCharts は期間のランキングと、期間の最終日までChartTrendDays日間の日別推移のグラフを描く
+func periodicSummaryCharts(ctx context.Context, store StateStore, period summaryPeriod, stats []PrefectureCount) []chartAttachment {
+	var charts []chartAttachment
+	last := period.End.AddDate(0, 0, -1)
+	rankingTitle := fmt.Sprintf("%s bear sightings by prefecture, %s - %s",
+		period.ChartLabel, period.Start.Format("2006-01-02"), last.Format("2006-01-02"))
+	if chart, err := renderRankingChart(rankingTitle, stats); err != nil {
+		log.Printf("Failed to render %s ranking chart: %v", period.Kind, err)
+	} else {
+		charts = append(charts, chartAttachment{Name: period.Kind + "-ranking", PNG: chart, Description: rankingChartDescription(period.Title+"の"+period.Label, stats)})
+	}
+
+	days, err := loadDailyCounts(ctx, store, period.End, ChartTrendDays)
+	if err != nil {
+		log.Printf("Failed to load daily counts for trend chart: %v", err)
+		return charts
+	}
+	trendTitle := fmt.Sprintf("Daily bear sightings, %d days to %s", ChartTrendDays, last.Format("2006-01-02"))
+	if chart, err := renderTrendChart(trendTitle, days); err != nil {
+		log.Printf("Failed to render trend chart: %v", err)
+	} else {
+		charts = append(charts, chartAttachment{Name: period.Kind + "-trend", PNG: chart, Description: trendChartDescription(days)})
+	}
+
+	return charts
+}
+
+// comparePrefectureStats は今期間のランキング順に前期間の件数を対応させる。前期間に0件だった都道府県は新規とする。
+func comparePrefectureStats(current, previous []PrefectureCount) []PrefectureTrend {
+	previousCounts := make(map[string]int, len(previous))
+	for _, stat := range previous {
+		previousCounts[stat.Prefecture] = stat.Count
+	}
+
+	trends := make([]PrefectureTrend, 0, len(current))
+	for _, stat := range current {
+		previousCount := previousCounts[stat.Prefecture]
+		trends = append(trends, PrefectureTrend{
+			Prefecture: stat.Prefecture,
+			Count:      stat.Count,
+			Previous:   previousCount,
+			New:        stat.Prefecture != OtherPrefecture && previousCount == 0,
+		})
+	}
+
+	return trends
+}
+
+func formatTrendChange(count, previous int) string {
+	switch diff := count - previous; {
+	case diff > 0:
+		return fmt.Sprintf("↑%d", diff)
+	case diff < 0:
+		return fmt.Sprintf("↓%d", -diff)
+	default:
+		return "±0"
+	}
+}
+
+// formatPeriodicSummary は500文字に収まるまでランキングの下位（と新規の都道府県の列挙）をまとめて省略する
+func formatPeriodicSummary(period summaryPeriod, trends []PrefectureTrend, total, previousTotal int) string {
+	ranked := trends
+	var other *PrefectureTrend
+	if len(ranked) > 0 && ranked[len(ranked)-1].Prefecture == OtherPrefecture {
+		other = &ranked[len(ranked)-1]
+		ranked = ranked[:len(ranked)-1]
+	}
+
+	for shown := len(ranked); ; shown-- {
+		ranking := formatPrefectureTrends(ranked[:shown], ranked[shown:], other)
+		content := fmt.Sprintf(PeriodicSummaryPostTemplate,
+			period.Title, period.Label, total, period.PreviousLabel, formatTrendChange(total, previousTotal),
+			period.PreviousLabel, ranking, formatNewPrefectures(ranked[:shown], ranked[shown:]))
+		if len([]rune(content)) <= MaxPostRunes || shown == 0 {
+			return content
+		}
+	}
+}
+
+func formatNewPrefectures(shown, omitted []PrefectureTrend) string {
+	var names []string
+	for _, trend := range shown {
+		if trend.New {
+			names = append(names, trend.Prefecture)
+		}
+	}
+	omittedNew := 0
+	for _, trend := range omitted {
+		if trend.New {
+			omittedNew++
+		}
+	}
+	if omittedNew > 0 {
+		names = append(names, fmt.Sprintf("ほか%d都道府県", omittedNew))
+	}
+
+	if len(names) == 0 {
+		return ""
+	}
+	return "\n\n🆕 新たに出没情報があった都道府県: " + strings.Join(names, "、")
+}
+
+func formatPrefectureTrends(shown, omitted []PrefectureTrend, other *PrefectureTrend) string {
+	var lines []string
+	currentRank := 1
+	prevCount := -1
+	for i, trend := range shown {
+		if prevCount != -1 && trend.Count < prevCount {
+			currentRank = i + 1
+		}
+		mark := ""
+		if trend.New {
+			mark = " 🆕"
+		}
+		lines = append(lines, fmt.Sprintf("%2d. %s：%d件（%s）%s", currentRank, trend.Prefecture, trend.Count, formatTrendChange(trend.Count, trend.Previous), mark))
+		prevCount = trend.Count
+	}
+
+	if len(omitted) > 0 {
+		omittedCount := 0
+		for _, trend := range omitted {
+			omittedCount += trend.Count
+		}
+		lines = append(lines, fmt.Sprintf("    ほか%d都道府県：%d件", len(omitted), omittedCount))
+	}
+	if other != nil {
+		lines = append(lines, fmt.Sprintf("    %s：%d件（%s）", other.Prefecture, other.Count, formatTrendChange(other.Count, other.Previous)))
+	}
+
+	return strings.Join(lines, "\n")
+}
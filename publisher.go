@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/mattn/go-mastodon"
+)
+
+// Publisher は記事や集計の投稿先。Mastodonを主な投稿先とし、設定された他の投稿先にも同じ内容を投稿する。
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, post *Publication) (string, error)
+	Pin(ctx context.Context, id string) error
+}
+
+// ErrUnsupportedPublication は投稿先が扱わない種類の投稿。その投稿先には投稿せずに次へ進む。
+var ErrUnsupportedPublication = errors.New("publication not supported")
+
+// Publication は投稿先に依存しない投稿内容。Articleは記事の投稿の場合のみ設定する。
+type Publication struct {
+	Text    string
+	Charts  []chartAttachment
+	Article *PostedURL
+}
+
+type mastodonPublisher struct {
+	config *Config
+	client *mastodon.Client
+}
+
+func (p *mastodonPublisher) Name() string {
+	return "mastodon"
+}
+
+func (p *mastodonPublisher) Publish(ctx context.Context, post *Publication) (string, error) {
+	status, err := postTootToMastodon(ctx, p.config, p.client, &mastodon.Toot{
+		Status:   post.Text,
+		MediaIDs: uploadCharts(ctx, p.client, post.Charts),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(status.ID), nil
+}
+
+func (p *mastodonPublisher) Pin(ctx context.Context, id string) error {
+	return pinSummaryPosts(ctx, p.client, mastodon.ID(id))
+}
+
+// mirrorPublishers はMastodonに加えて投稿する、設定済みの投稿先を返す
+func mirrorPublishers(config *Config) []Publisher {
+	var publishers []Publisher
+	if config.Misskey.Server != "" {
+		publishers = append(publishers, newMisskeyPublisher(config))
+	}
+	if config.Bluesky.Identifier != "" {
+		publishers = append(publishers, newBlueskyPublisher(config))
+	}
+	return publishers
+}
+
+// publish はMastodonに投稿してから他の投稿先にも投稿し、Mastodonの投稿IDを返す。
+// 他の投稿先への投稿の失敗はログに残すだけで、Mastodonへの投稿の成否には影響しない。pinを指定すると投稿を固定する。
+func publish(ctx context.Context, config *Config, client *mastodon.Client, post *Publication, pin bool) (string, error) {
+	primary := &mastodonPublisher{config: config, client: client}
+	id, err := primary.Publish(ctx, post)
+	if err != nil {
+		return "", err
+	}
+	if pin {
+		if err := primary.Pin(ctx, id); err != nil {
+			log.Printf("Failed to pin post on %s: %v", primary.Name(), err)
+		}
+	}
+
+	for _, publisher := range mirrorPublishers(config) {
+		mirrorID, err := publisher.Publish(ctx, post)
+		if errors.Is(err, ErrUnsupportedPublication) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to publish to %s: %v", publisher.Name(), err)
+			continue
+		}
+		if pin {
+			if err := publisher.Pin(ctx, mirrorID); err != nil {
+				log.Printf("Failed to pin post on %s: %v", publisher.Name(), err)
+			}
+		}
+	}
+
+	return id, nil
+}
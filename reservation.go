@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	PostStatusPosting = "posting"
+	ReconcileMargin   = 10 * time.Minute
+)
+
+type idempotencyKeyContextKey struct{}
+
+// idempotencyTransport はcontextに設定された冪等キーをIdempotency-Keyヘッダーとして付与する
+type idempotencyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := req.Context().Value(idempotencyKeyContextKey{}).(string)
+	if !ok || key == "" || req.Method != http.MethodPost {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Idempotency-Key", key)
+	return t.base.RoundTrip(req)
+}
+
+func withIdempotencyKey(ctx context.Context, articleURL string) context.Context {
+	sum := sha256.Sum256([]byte(articleURL))
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, "kuma-bot-"+hex.EncodeToString(sum[:16]))
+}
+
+func reservePostedURLs(ctx context.Context, store StateStore, config *Config, articles []PostedURL) error {
+	if len(articles) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	reservations := make([]PostedURL, len(articles))
+	for i, article := range articles {
+		article.Status = PostStatusPosting
+		article.PostedAt = now
+		reservations[i] = article
+	}
+
+	return savePostedURLs(ctx, store, config, reservations)
+}
+
+// reconcilePendingPosts は前回の実行で投稿中のまま残った記事を自アカウントの投稿と突き合わせる。
+// 投稿が見つかったものは投稿済みに、見つからなかったものは予約を取り消して次回以降の再投稿対象にする。
+func reconcilePendingPosts(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, postedURLs []PostedURL) ([]PostedURL, error) {
+	var pending []PostedURL
+	since := time.Now()
+	for _, posted := range postedURLs {
+		if posted.Status != PostStatusPosting {
+			continue
+		}
+		pending = append(pending, posted)
+		if posted.PostedAt.Before(since) {
+			since = posted.PostedAt
+		}
+	}
+
+	if len(pending) == 0 {
+		return postedURLs, nil
+	}
+
+	log.Printf("Reconciling %d pending posts from a previous run", len(pending))
+	toots, err := fetchRecentToots(ctx, client, since.Add(-ReconcileMargin))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent toots: %w", err)
+	}
+
+	var confirmed, abandoned []PostedURL
+	for _, posted := range pending {
+		if toot := findTootForURL(toots, posted.URL); toot != nil {
+			posted.Status = ""
+			posted.StatusID = string(toot.ID)
+			posted.PostedAt = toot.CreatedAt
+			confirmed = append(confirmed, posted)
+		} else {
+			log.Printf("Pending post '%s' was not found on the timeline, releasing it for retry", posted.Title)
+			abandoned = append(abandoned, posted)
+		}
+	}
+
+	reconcile := func(current []PostedURL) []PostedURL {
+		return excludePostedURLs(mergePostedURLs(current, confirmed), abandoned)
+	}
+	if err := updatePostedURLs(ctx, store, config, reconcile); err != nil {
+		return nil, fmt.Errorf("failed to save reconciled posts: %w", err)
+	}
+
+	var records []ArchiveRecord
+	for _, posted := range confirmed {
+		records = append(records, newArchiveRecord(posted))
+	}
+	if err := appendArchiveRecords(ctx, store, records); err != nil {
+		log.Printf("Failed to archive reconciled posts: %v", err)
+	}
+
+	return reconcile(postedURLs), nil
+}
+
+func findTootForURL(toots []*mastodon.Status, articleURL string) *mastodon.Status {
+	escapedURL := html.EscapeString(articleURL)
+	for _, toot := range toots {
+		if strings.Contains(toot.Content, articleURL) || strings.Contains(toot.Content, escapedURL) {
+			return toot
+		}
+	}
+
+	return nil
+}
+
+func excludePostedURLs(postedURLs, excluded []PostedURL) []PostedURL {
+	excludedURLs := make(map[string]struct{}, len(excluded))
+	for _, posted := range excluded {
+		excludedURLs[posted.URL] = struct{}{}
+	}
+
+	var remaining []PostedURL
+	for _, posted := range postedURLs {
+		if _, exists := excludedURLs[posted.URL]; !exists {
+			remaining = append(remaining, posted)
+		}
+	}
+
+	return remaining
+}
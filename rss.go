@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+type rssSource struct {
+	name      string
+	hashtag   string
+	rssConfig *RSSConfig
+	env       *sourceEnv
+}
+
+func newRSSSource(sourceConfig SourceConfig, env *sourceEnv) (Source, error) {
+	return &rssSource{
+		name:      sourceConfig.Name,
+		hashtag:   hashtagOrDefault(sourceConfig, RSSHashtag),
+		rssConfig: env.rssConfig,
+		env:       env,
+	}, nil
+}
+
+func (s *rssSource) Name() string {
+	return s.name
+}
+
+func (s *rssSource) Hashtag() string {
+	return s.hashtag
+}
+
+func (s *rssSource) FormatPost(article *PostedURL) string {
+	var location string
+	hashtags := []string{s.hashtag}
+	if prefectures := articlePrefectures(*article); len(prefectures) > 0 {
+		location = "\n\n📍 " + strings.Join(prefectures, "・")
+		for _, prefecture := range prefectures {
+			hashtags = append(hashtags, "#"+prefecture)
+		}
+	}
+
+	post := fmt.Sprintf(RSSNewsTemplate, article.Title, article.URL, article.Description, location, strings.Join(hashtags, " "))
+	if len([]rune(post)) > 500 {
+		post = fmt.Sprintf(RSSNewsTemplate, article.Title, article.URL, "", location, strings.Join(hashtags, " "))
+	}
+	return post
+}
+
+// Fetch は全フィードを最大max_concurrency件ずつ並行に取得し、設定ファイルのフィード順に結果をまとめる。
+// すべてのフィードの取得に失敗した場合はエラーを返す。
+func (s *rssSource) Fetch(ctx context.Context) ([]PostedURL, error) {
+	feeds, failed := s.fetchFeeds(ctx)
+	if failed > 0 && failed == len(s.rssConfig.RSSSources) {
+		return nil, fmt.Errorf("failed to fetch all %d RSS feeds", failed)
+	}
+
+	seenURLs := make(map[string]struct{})
+	var allArticles []PostedURL
+	for i, feed := range feeds {
+		if feed == nil {
+			continue
+		}
+
+		for _, item := range feed.Items {
+			if item.Link == "" || item.PublishedParsed == nil {
+				continue
+			}
+
+			if _, exists := seenURLs[item.Link]; exists {
+				continue
+			}
+
+			var description string
+			if item.Description != "" {
+				description = item.Description
+				doc, err := goquery.NewDocumentFromReader(strings.NewReader(description))
+				if err == nil {
+					description = doc.Text()
+				}
+				description = "\n\n🔗 " + strings.TrimSpace(description) + "…"
+			}
+			if !isBearRelatedNews(item.Title, description, s.rssConfig) {
+				continue
+			}
+
+			article := PostedURL{
+				URL:         item.Link,
+				Title:       item.Title,
+				Description: description,
+				PublishedAt: *item.PublishedParsed,
+				fetchedFrom: []string{s.rssConfig.RSSSources[i]},
+			}
+			if inferred := inferPrefectures(item.Title + " " + description); len(inferred) > 0 {
+				article.Prefecture = inferred[0]
+				article.Prefectures = inferred
+			}
+
+			allArticles = append(allArticles, article)
+			seenURLs[item.Link] = struct{}{}
+		}
+	}
+
+	return allArticles, nil
+}
+
+// fetchFeeds は取得したフィード（未更新・失敗の場合はnil）と失敗したフィードの数を返す
+func (s *rssSource) fetchFeeds(ctx context.Context) ([]*gofeed.Feed, int) {
+	concurrency := s.rssConfig.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultRSSConcurrency
+	}
+
+	feeds := make([]*gofeed.Feed, len(s.rssConfig.RSSSources))
+	semaphore := make(chan struct{}, concurrency)
+	var failed atomic.Int32
+	var wg sync.WaitGroup
+	for i, rssURL := range s.rssConfig.RSSSources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				log.Printf("Skipped RSS from %s: %v", rssURL, ctx.Err())
+				failed.Add(1)
+				return
+			}
+
+			feedCtx, cancel := context.WithTimeout(ctx, s.feedTimeout(rssURL))
+			defer cancel()
+
+			healthName := s.name + ":" + rssURL
+			body, err := fetchURL(feedCtx, rssURL, s.env.cache)
+			if errors.Is(err, ErrNotModified) {
+				s.env.health.recordNotModified(healthName)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to fetch RSS from %s: %v", rssURL, err)
+				s.env.health.recordFailure(healthName, err)
+				failed.Add(1)
+				return
+			}
+
+			feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+			if err != nil {
+				// 検証子を残すと次回は304になり、壊れたフィードを取得し直さないまま正常と扱ってしまう
+				if s.env.cache != nil {
+					s.env.cache.restore(rssURL)
+				}
+				log.Printf("Failed to parse RSS from %s: %v", rssURL, err)
+				s.env.health.recordFailure(healthName, err)
+				failed.Add(1)
+				return
+			}
+			s.env.health.recordSuccess(healthName, len(feed.Items))
+			feeds[i] = feed
+		}()
+	}
+	wg.Wait()
+
+	return feeds, int(failed.Load())
+}
+
+func (s *rssSource) feedTimeout(rssURL string) time.Duration {
+	if seconds, ok := s.rssConfig.FeedTimeouts[rssURL]; ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if s.rssConfig.FeedTimeoutSeconds > 0 {
+		return time.Duration(s.rssConfig.FeedTimeoutSeconds) * time.Second
+	}
+	return HTTPTimeout
+}
+
+func isBearRelatedNews(title, description string, rssConfig *RSSConfig) bool {
+	text := title + " " + description
+
+	for _, keyword := range rssConfig.ExcludeKeywords {
+		if strings.Contains(text, keyword) {
+			return false
+		}
+	}
+
+	for _, keyword := range rssConfig.IncludeKeywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
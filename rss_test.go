@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestRSSFetchRetriesUnparsableFeed は解析に失敗したフィードの検証子を保存せず、次の実行でも取得し直して失敗と数えることを確認する
+func TestRSSFetchRetriesUnparsableFeed(t *testing.T) {
+	const etag = `"broken-feed"`
+	var mu sync.Mutex
+	var fullResponses int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("<html>not a feed</html>"))
+	}))
+	defer server.Close()
+
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	rssConfig := &RSSConfig{RSSSources: []string{server.URL}}
+	healthName := SourceRSS + ":" + server.URL
+
+	for run := 1; run <= 2; run++ {
+		cache, err := loadHTTPCache(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		health, err := loadHealthTracker(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := newRSSSource(SourceConfig{Type: SourceRSS, Name: SourceRSS}, &sourceEnv{rssConfig: rssConfig, cache: cache, health: health})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := source.Fetch(ctx); err == nil {
+			t.Fatalf("run %d: Fetch succeeded for an unparsable feed", run)
+		}
+
+		if got := health.records[healthName].ConsecutiveFailures; got != run {
+			t.Errorf("run %d: ConsecutiveFailures = %d, want %d", run, got, run)
+		}
+		if err := cache.save(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		if err := health.save(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fullResponses != 2 {
+		t.Errorf("feed fetched in full %d times, want 2", fullResponses)
+	}
+}
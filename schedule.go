@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	SummaryStateKey           = "summary_state.json"
+	DefaultSummaryTime        = "0:00"
+	DefaultSummaryCatchUpDays = 7
+	summaryTimeLayout         = "15:04"
+)
+
+type SummaryConfig struct {
+	Time        string `json:"time"`
+	CatchUpDays int    `json:"catch_up_days"`
+}
+
+// SummaryState は最後に日別集計を投稿した集計対象日（JST）
+type SummaryState struct {
+	LastDate  string    `json:"last_date"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func parseSummaryTime(value string) (time.Time, error) {
+	summaryTime, err := time.Parse(summaryTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid summary time '%s': %w", value, err)
+	}
+	return summaryTime, nil
+}
+
+// latestSummaryDate は現在時刻までに投稿時刻を迎えた最新の集計対象日を返す。集計対象日は投稿日の前日。
+func latestSummaryDate(now time.Time, summaryTime time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	postAt := today.Add(time.Duration(summaryTime.Hour())*time.Hour + time.Duration(summaryTime.Minute())*time.Minute)
+	if now.Before(postAt) {
+		return today.AddDate(0, 0, -2)
+	}
+	return today.AddDate(0, 0, -1)
+}
+
+// missedSummaryDates は前回の集計対象日の翌日からlatestまでの集計対象日を返す。catchUpDaysより古い日は諦める。
+func missedSummaryDates(lastDate, latest time.Time, catchUpDays int) []time.Time {
+	start := lastDate.AddDate(0, 0, 1)
+	earliest := latest.AddDate(0, 0, -(catchUpDays - 1))
+	if start.Before(earliest) {
+		log.Printf("Skipping summaries from %s to %s older than %d days",
+			start.Format(ArchiveDateFormat), earliest.AddDate(0, 0, -1).Format(ArchiveDateFormat), catchUpDays)
+		start = earliest
+	}
+
+	var dates []time.Time
+	for date := start; !date.After(latest); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// runScheduledSummaries は前回の集計以降に投稿時刻を迎えた日別集計（と同じ日に投稿する週間・月間集計）を、
+// 本来の集計対象日で順に投稿する。forceを指定すると前日分を投稿時刻に関係なく投稿する。
+func runScheduledSummaries(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, force string) error {
+	summaryTime, err := parseSummaryTime(config.Summary.Time)
+	if err != nil {
+		return err
+	}
+
+	jst := time.FixedZone("JST", JSTOffset)
+	now := time.Now().In(jst)
+	latest := latestSummaryDate(now, summaryTime)
+
+	var state SummaryState
+	if err := loadJSON(ctx, store, SummaryStateKey, &state); err != nil && !errors.Is(err, ErrStateNotFound) {
+		return fmt.Errorf("failed to load summary state: %w", err)
+	}
+
+	var lastDate time.Time
+	if state.LastDate != "" {
+		lastDate, err = time.ParseInLocation(ArchiveDateFormat, state.LastDate, jst)
+		if err != nil {
+			return fmt.Errorf("invalid last summary date '%s': %w", state.LastDate, err)
+		}
+	}
+
+	var dates []time.Time
+	switch {
+	case force != "":
+		yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, jst).AddDate(0, 0, -1)
+		dates = []time.Time{yesterday}
+	case lastDate.IsZero():
+		// 初回は導入前の集計と重ならないよう、投稿せずに基準日だけを記録する
+		log.Printf("Initializing summary state with %s", latest.Format(ArchiveDateFormat))
+		return saveSummaryState(ctx, store, latest)
+	default:
+		dates = missedSummaryDates(lastDate, latest, config.Summary.CatchUpDays)
+	}
+
+	for _, date := range dates {
+		if err := runSummaryForDate(ctx, store, config, client, date, force); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runSummaryForDate はdateの日別集計と、その翌日に投稿する週間・月間集計を投稿する。forceは週間・月間集計の強制指定。
+func runSummaryForDate(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, date time.Time, force string) error {
+	log.Printf("Starting prefecture summary for %s", date.Format(ArchiveDateFormat))
+	if err := runPrefectureSummary(ctx, store, config, client, date); err != nil {
+		return fmt.Errorf("failed to run prefecture summary for %s: %w", date.Format(ArchiveDateFormat), err)
+	}
+	if err := runPeriodicSummaries(ctx, store, config, client, date.AddDate(0, 0, 1), force); err != nil {
+		log.Printf("Failed to run periodic summaries: %v", err)
+	}
+
+	// 定時の集計で同じ日を再投稿しないよう、最後に集計した日より新しければ記録する
+	var state SummaryState
+	if err := loadJSON(ctx, store, SummaryStateKey, &state); err != nil && !errors.Is(err, ErrStateNotFound) {
+		return fmt.Errorf("failed to load summary state: %w", err)
+	}
+	if state.LastDate < date.Format(ArchiveDateFormat) {
+		return saveSummaryState(ctx, store, date)
+	}
+	return nil
+}
+
+func saveSummaryState(ctx context.Context, store StateStore, date time.Time) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would save last summary date %s", date.Format(ArchiveDateFormat))
+		return nil
+	}
+
+	state := SummaryState{LastDate: date.Format(ArchiveDateFormat), UpdatedAt: time.Now()}
+	if err := saveJSON(ctx, store, SummaryStateKey, state); err != nil {
+		return fmt.Errorf("failed to save summary state: %w", err)
+	}
+	return nil
+}
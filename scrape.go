@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/width"
+)
+
+const SourceScrape = "scrape"
+
+var eraYearRegex = regexp.MustCompile(`(令和|平成)(元|\d+)年`)
+
+var eraBaseYears = map[string]int{
+	"令和": 2018,
+	"平成": 1988,
+}
+
+// ScrapeConfig はHTMLページから記事一覧を取得するためのセレクタ定義
+type ScrapeConfig struct {
+	URL            string   `json:"url"`
+	Label          string   `json:"label"`
+	PageParam      string   `json:"page_param"`
+	MaxPages       int      `json:"max_pages"`
+	ItemSelector   string   `json:"item_selector"`
+	TitleSelector  string   `json:"title_selector"`
+	LinkSelector   string   `json:"link_selector"`
+	DateSelector   string   `json:"date_selector"`
+	DatePattern    string   `json:"date_pattern"`
+	DateFormats    []string `json:"date_formats"`
+	RegionSelector string   `json:"region_selector"`
+	Region         string   `json:"region"`
+}
+
+type scrapeSource struct {
+	name        string
+	hashtag     string
+	config      ScrapeConfig
+	datePattern *regexp.Regexp
+	env         *sourceEnv
+}
+
+func newScrapeSource(sourceConfig SourceConfig, env *sourceEnv) (Source, error) {
+	scrapeConfig := sourceConfig.Scrape
+	if scrapeConfig == nil {
+		return nil, fmt.Errorf("scrape settings are required")
+	}
+	if scrapeConfig.URL == "" || scrapeConfig.ItemSelector == "" || scrapeConfig.TitleSelector == "" {
+		return nil, fmt.Errorf("url, item_selector and title_selector are required")
+	}
+	if scrapeConfig.DateSelector != "" && len(scrapeConfig.DateFormats) == 0 {
+		return nil, fmt.Errorf("date_formats are required when date_selector is set")
+	}
+
+	source := &scrapeSource{
+		name:    sourceConfig.Name,
+		hashtag: hashtagOrDefault(sourceConfig, KumaHashtag),
+		config:  *scrapeConfig,
+		env:     env,
+	}
+	if source.config.Label == "" {
+		source.config.Label = sourceConfig.Name
+	}
+	if source.config.MaxPages <= 0 {
+		source.config.MaxPages = 1
+	}
+	if scrapeConfig.DatePattern != "" {
+		pattern, err := regexp.Compile(scrapeConfig.DatePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date_pattern: %w", err)
+		}
+		source.datePattern = pattern
+	}
+
+	return source, nil
+}
+
+func (s *scrapeSource) Name() string {
+	return s.name
+}
+
+func (s *scrapeSource) Hashtag() string {
+	return s.hashtag
+}
+
+func (s *scrapeSource) FormatPost(article *PostedURL) string {
+	return fmt.Sprintf(KumaPostTemplate, article.Title, article.URL, article.Description, s.hashtag)
+}
+
+func (s *scrapeSource) Fetch(ctx context.Context) ([]PostedURL, error) {
+	var allArticles []PostedURL
+	var firstPageURL string
+	for page := 1; page <= s.config.MaxPages; page++ {
+		pageURL, err := s.pageURL(page)
+		if err != nil {
+			return nil, err
+		}
+
+		if page == 1 {
+			firstPageURL = pageURL.String()
+		}
+
+		doc, err := fetchDocument(ctx, pageURL.String(), s.env.cache)
+		if errors.Is(err, ErrNotModified) {
+			if page == 1 {
+				s.env.health.recordNotModified(s.name)
+				return nil, nil
+			}
+			continue
+		}
+		if err != nil {
+			if page == 1 {
+				s.env.health.recordFailure(s.name, err)
+				return nil, err
+			}
+			log.Printf("Failed to fetch page %d of %s, stopping: %v", page, s.name, err)
+			break
+		}
+
+		articles := s.parseArticles(doc, pageURL)
+		if len(articles) == 0 && page > 1 {
+			break
+		}
+		for i := range articles {
+			articles[i].fetchedFrom = []string{firstPageURL, pageURL.String()}
+		}
+
+		allArticles = append(allArticles, articles...)
+	}
+
+	s.env.health.recordSuccess(s.name, len(allArticles))
+	return allArticles, nil
+}
+
+func (s *scrapeSource) pageURL(page int) (*url.URL, error) {
+	pageURL, err := url.Parse(s.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url '%s': %w", s.config.URL, err)
+	}
+
+	if s.config.PageParam != "" && s.config.MaxPages > 1 {
+		query := pageURL.Query()
+		query.Set(s.config.PageParam, strconv.Itoa(page))
+		pageURL.RawQuery = query.Encode()
+	}
+
+	return pageURL, nil
+}
+
+func (s *scrapeSource) parseArticles(doc *goquery.Document, pageURL *url.URL) []PostedURL {
+	var articles []PostedURL
+	doc.Find(s.config.ItemSelector).Each(func(i int, item *goquery.Selection) {
+		titleSelection := item.Find(s.config.TitleSelector).First()
+		title := strings.TrimSpace(titleSelection.Text())
+		if title == "" {
+			return
+		}
+
+		href, ok := s.findLink(item, titleSelection)
+		if !ok {
+			log.Printf("Skipping item '%s' from %s: link not found", title, s.name)
+			return
+		}
+		link, err := pageURL.Parse(href)
+		if err != nil {
+			log.Printf("Skipping item '%s' from %s: invalid link '%s': %v", title, s.name, href, err)
+			return
+		}
+
+		publishedAt := time.Now()
+		var dateText string
+		if s.config.DateSelector != "" {
+			dateText = strings.TrimSpace(item.Find(s.config.DateSelector).First().Text())
+			publishedAt, err = s.parseDate(dateText)
+			if err != nil {
+				log.Printf("Skipping item '%s' from %s due to date parse error: %v", title, s.name, err)
+				return
+			}
+		}
+
+		region := s.config.Region
+		if s.config.RegionSelector != "" {
+			if text := strings.TrimSpace(item.Find(s.config.RegionSelector).First().Text()); text != "" {
+				region = text
+			}
+		}
+
+		articles = append(articles, PostedURL{
+			URL:         link.String(),
+			Title:       title,
+			Description: strings.Join(strings.Fields(fmt.Sprintf("%s %s %s", region, s.config.Label, dateText)), " "),
+			PublishedAt: publishedAt,
+			Prefecture:  extractPrefecture(region),
+		})
+	})
+
+	return articles
+}
+
+func (s *scrapeSource) findLink(item, titleSelection *goquery.Selection) (string, bool) {
+	if s.config.LinkSelector != "" {
+		return item.Find(s.config.LinkSelector).First().Attr("href")
+	}
+	if href, ok := titleSelection.Closest("a").Attr("href"); ok {
+		return href, true
+	}
+	if href, ok := titleSelection.Find("a").First().Attr("href"); ok {
+		return href, true
+	}
+	return item.Find("a").First().Attr("href")
+}
+
+// parseDate は全角数字や和暦を正規化してから設定された書式で日時を解釈する。
+// 書式に年が含まれない場合は現在の年とし、未来になる場合は前年とみなす。
+func (s *scrapeSource) parseDate(text string) (time.Time, error) {
+	normalized := normalizeDateText(text)
+	if s.datePattern != nil {
+		matches := s.datePattern.FindStringSubmatch(normalized)
+		if matches == nil {
+			return time.Time{}, fmt.Errorf("date '%s' does not match pattern", text)
+		}
+		normalized = matches[len(matches)-1]
+	}
+
+	jst := time.FixedZone("JST", JSTOffset)
+	nowJST := time.Now().In(jst)
+	for _, format := range s.config.DateFormats {
+		parsed, err := time.ParseInLocation(format, normalized, jst)
+		if err != nil {
+			continue
+		}
+		if parsed.Year() == 0 {
+			parsed = parsed.AddDate(nowJST.Year(), 0, 0)
+			if parsed.After(nowJST) {
+				parsed = parsed.AddDate(-1, 0, 0)
+			}
+		}
+		return parsed, nil
+	}
+
+	return time.Time{}, fmt.Errorf("date '%s' does not match any of %v", text, s.config.DateFormats)
+}
+
+func normalizeDateText(text string) string {
+	text = strings.TrimSpace(width.Narrow.String(text))
+	return eraYearRegex.ReplaceAllStringFunc(text, func(match string) string {
+		parts := eraYearRegex.FindStringSubmatch(match)
+		year := 1
+		if parts[2] != "元" {
+			year, _ = strconv.Atoi(parts[2])
+		}
+		return fmt.Sprintf("%d年", eraBaseYears[parts[1]]+year)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultScrapeIntervalMinutes = 10
+	DefaultServeJitterSeconds    = 30
+)
+
+// ServeConfig は常駐モード（serve）のスケジュール設定
+type ServeConfig struct {
+	ScrapeIntervalMinutes int            `json:"scrape_interval_minutes"`
+	SourceIntervalMinutes map[string]int `json:"source_interval_minutes"`
+	// JitterSecondsは省略時にDefaultServeJitterSecondsになる。0または負の値ではずらさない。
+	JitterSeconds *int `json:"jitter_seconds"`
+}
+
+func (c ServeConfig) jitter() time.Duration {
+	if c.JitterSeconds == nil || *c.JitterSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(*c.JitterSeconds) * time.Second
+}
+
+// serveJob は常駐モードで繰り返し実行する処理。intervalが0の場合はtimesの時刻（JST）に毎日実行する。
+type serveJob struct {
+	name     string
+	event    KumaBotEvent
+	interval time.Duration
+	times    []time.Time
+	next     time.Time
+}
+
+func (j *serveJob) schedule(now time.Time, jitter time.Duration) {
+	if j.interval > 0 {
+		j.next = now.Add(j.interval)
+	} else {
+		j.next = nextDailyTime(now, j.times)
+	}
+	if jitter > 0 {
+		j.next = j.next.Add(rand.N(jitter))
+	}
+}
+
+// nextDailyTime はnowより後で最も早い、timesのいずれかの時刻（JST）を返す
+func nextDailyTime(now time.Time, times []time.Time) time.Time {
+	jst := time.FixedZone("JST", JSTOffset)
+	now = now.In(jst)
+	var next time.Time
+	for _, t := range times {
+		candidate := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, jst)
+		if !candidate.After(now) {
+			candidate = candidate.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+// buildServeJobs は集計の確認と、取得間隔ごとにまとめた取得元の取得のジョブを作る。
+// 集計はsummary.timeを過ぎてから投稿する設定なので、集計の確認もsummary.timeに行う。
+func buildServeJobs(config *Config, rssConfig *RSSConfig) ([]*serveJob, error) {
+	summaryTime, err := parseSummaryTime(config.Summary.Time)
+	if err != nil {
+		return nil, fmt.Errorf("summary.time: %w", err)
+	}
+	summaryJob := &serveJob{
+		name:  "summary",
+		event: KumaBotEvent{Mode: EventModeScheduledSummary},
+		times: []time.Time{summaryTime},
+	}
+
+	sourceConfigs := rssConfig.Sources
+	if len(sourceConfigs) == 0 {
+		sourceConfigs = defaultSourceConfigs
+	}
+	known := make(map[string]struct{}, len(sourceConfigs))
+	var intervals []int
+	namesByInterval := make(map[int][]string)
+	for _, sourceConfig := range sourceConfigs {
+		name := sourceConfig.Name
+		if name == "" {
+			name = sourceConfig.Type
+		}
+		known[name] = struct{}{}
+
+		minutes := config.Serve.ScrapeIntervalMinutes
+		if override, ok := config.Serve.SourceIntervalMinutes[name]; ok && override > 0 {
+			minutes = override
+		}
+		if _, exists := namesByInterval[minutes]; !exists {
+			intervals = append(intervals, minutes)
+		}
+		namesByInterval[minutes] = append(namesByInterval[minutes], name)
+	}
+	for name := range config.Serve.SourceIntervalMinutes {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("serve.source_interval_minutes: unknown source '%s'", name)
+		}
+	}
+
+	jobs := []*serveJob{summaryJob}
+	for _, minutes := range intervals {
+		names := namesByInterval[minutes]
+		jobs = append(jobs, &serveJob{
+			name:     fmt.Sprintf("scrape every %dm (%s)", minutes, strings.Join(names, ", ")),
+			event:    KumaBotEvent{Mode: EventModeScrape, Sources: names},
+			interval: time.Duration(minutes) * time.Minute,
+		})
+	}
+	return jobs, nil
+}
+
+// runServe はスケジュールに従ってジョブを1つずつ実行し続ける。SIGINT / SIGTERMを受けると実行中のジョブを最後まで終えてから終了する。
+func runServe(ctx context.Context, args []string) error {
+	flags := newFlagSet("serve")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, store, err := openCLIState(ctx)
+	if err != nil {
+		return err
+	}
+	rssConfig, err := loadRSSConfig(ctx, store, config)
+	store.Close()
+	if err != nil {
+		return fmt.Errorf("failed to load RSS config: %w", err)
+	}
+
+	jobs, err := buildServeJobs(config, rssConfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 起動時は停止中に抜けた集計を先に投稿し、取得はジッターをずらして始める
+	jitter := config.Serve.jitter()
+	now := time.Now()
+	for _, job := range jobs {
+		job.next = now
+		if job.interval > 0 && jitter > 0 {
+			job.next = now.Add(rand.N(jitter))
+		}
+	}
+
+	log.Printf("Serving %d jobs", len(jobs))
+	for {
+		job := jobs[0]
+		for _, candidate := range jobs[1:] {
+			if candidate.next.Before(job.next) {
+				job = candidate
+			}
+		}
+
+		timer := time.NewTimer(time.Until(job.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Shutting down")
+			return nil
+		case <-timer.C:
+		}
+
+		// シグナルで実行中の投稿や保存が途切れないよう、ジョブにはキャンセルを伝えない
+		log.Printf("Running job '%s'", job.name)
+		if _, err := handleKumaBotRequest(context.WithoutCancel(ctx), job.event); err != nil {
+			log.Printf("Job '%s' failed: %v", job.name, err)
+		}
+		job.schedule(time.Now(), jitter)
+		log.Printf("Next run of '%s' at %s", job.name, formatJST(job.next))
+
+		if ctx.Err() != nil {
+			log.Println("Shutting down after the running job")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Source は記事の取得元。取得した記事は共通の重複判定を経て投稿される。
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context) ([]PostedURL, error)
+	FormatPost(article *PostedURL) string
+	Hashtag() string
+}
+
+// SourceConfig はRSS設定ファイルのsourcesに記述する取得元の定義
+type SourceConfig struct {
+	Type    string        `json:"type"`
+	Name    string        `json:"name"`
+	Hashtag string        `json:"hashtag"`
+	Scrape  *ScrapeConfig `json:"scrape,omitempty"`
+}
+
+// sourceEnv は取得元の生成時に渡す共有の依存
+type sourceEnv struct {
+	rssConfig *RSSConfig
+	cache     *httpCache
+	health    *healthTracker
+}
+
+type SourceFactory func(sourceConfig SourceConfig, env *sourceEnv) (Source, error)
+
+var sourceFactories = map[string]SourceFactory{
+	SourceDocomo: newDocomoSource,
+	SourceRSS:    newRSSSource,
+	SourceScrape: newScrapeSource,
+}
+
+var defaultSourceConfigs = []SourceConfig{
+	{Type: SourceDocomo},
+	{Type: SourceRSS},
+}
+
+type sourceBatch struct {
+	source   Source
+	articles []PostedURL
+}
+
+func buildSources(env *sourceEnv) ([]Source, error) {
+	sourceConfigs := env.rssConfig.Sources
+	if len(sourceConfigs) == 0 {
+		sourceConfigs = defaultSourceConfigs
+	}
+
+	names := make(map[string]struct{}, len(sourceConfigs))
+	var sources []Source
+	for _, sourceConfig := range sourceConfigs {
+		factory, ok := sourceFactories[sourceConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown source type '%s'", sourceConfig.Type)
+		}
+		if sourceConfig.Name == "" {
+			sourceConfig.Name = sourceConfig.Type
+		}
+		if _, exists := names[sourceConfig.Name]; exists {
+			return nil, fmt.Errorf("duplicate source name '%s'", sourceConfig.Name)
+		}
+		names[sourceConfig.Name] = struct{}{}
+
+		source, err := factory(sourceConfig, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create source '%s': %w", sourceConfig.Name, err)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// filterSources はnamesに指定した取得元だけを設定の順に返す。namesが空の場合はすべての取得元を返す。
+func filterSources(sources []Source, names []string) ([]Source, error) {
+	if len(names) == 0 {
+		return sources, nil
+	}
+
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[name] = struct{}{}
+	}
+
+	var filtered []Source
+	for _, source := range sources {
+		if _, ok := wanted[source.Name()]; ok {
+			filtered = append(filtered, source)
+			delete(wanted, source.Name())
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown source '%s'", name)
+	}
+	return filtered, nil
+}
+
+// collectNewArticles は各取得元から記事を取得し、投稿済みおよび先に処理した取得元と重複しない記事を返す。
+// 重複判定は正規化したURLで行い、投稿には元のURLを使う。
+// 一部の取得元の失敗はログに残して稼働状況の通知に任せ、すべての取得元が失敗した場合だけエラーを返す。
+func collectNewArticles(ctx context.Context, sources []Source, canonicalizer *urlCanonicalizer, existingURLMap map[string]struct{}) ([]sourceBatch, error) {
+	var batches []sourceBatch
+	var errs []error
+	for _, source := range sources {
+		articles, err := source.Fetch(ctx)
+		if err != nil {
+			log.Printf("Failed to fetch articles from source '%s': %v", source.Name(), err)
+			errs = append(errs, fmt.Errorf("source '%s': %w", source.Name(), err))
+			continue
+		}
+
+		var newArticles []PostedURL
+		for _, article := range articles {
+			// rel=canonicalで置き換えた記事も元のURLの正規化結果（AliasURL）で登録済みなので、ページを取得せずに除外できる
+			key := canonicalizer.canonicalize(article.URL)
+			if _, exists := existingURLMap[key]; exists {
+				continue
+			}
+
+			// rel=canonicalの解決は記事ページの取得が必要なため、新規記事に限って行う
+			if canonicalizer.resolveCanonical {
+				if link := resolveCanonicalLink(ctx, article.URL); link != "" {
+					if canonicalKey := canonicalizer.canonicalize(link); canonicalKey != key {
+						existingURLMap[key] = struct{}{}
+						if _, exists := existingURLMap[canonicalKey]; exists {
+							continue
+						}
+						article.AliasURL = key
+						key = canonicalKey
+					}
+				}
+			}
+
+			article.Source = source.Name()
+			article.CanonicalURL = key
+			locateArticle(&article)
+			newArticles = append(newArticles, article)
+			existingURLMap[key] = struct{}{}
+		}
+
+		if len(newArticles) == 0 {
+			continue
+		}
+
+		sort.SliceStable(newArticles, func(i, j int) bool {
+			return newArticles[i].PublishedAt.Before(newArticles[j].PublishedAt)
+		})
+		batches = append(batches, sourceBatch{source: source, articles: newArticles})
+	}
+
+	if len(sources) > 0 && len(errs) == len(sources) {
+		return nil, fmt.Errorf("failed to fetch articles from all sources: %w", errors.Join(errs...))
+	}
+	return batches, nil
+}
+
+func hashtagOrDefault(sourceConfig SourceConfig, defaultHashtag string) string {
+	if sourceConfig.Hashtag != "" {
+		return sourceConfig.Hashtag
+	}
+	return defaultHashtag
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// StateExport はstate exportで書き出す状態。キーごとの内容をそのまま文字列として持つ。
+type StateExport struct {
+	ExportedAt string            `json:"exported_at"`
+	Entries    map[string]string `json:"entries"`
+}
+
+func runStateCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: state list|prune|export|import")
+	}
+
+	config, store, err := openCLIState(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		return runStateList(ctx, store, args)
+	case "prune":
+		return withRunLease(ctx, store, func() error {
+			return runStatePrune(ctx, store, config)
+		})
+	case "export":
+		return runStateExport(ctx, store, args)
+	case "import":
+		return withRunLease(ctx, store, func() error {
+			return runStateImport(ctx, store, args)
+		})
+	default:
+		return fmt.Errorf("unknown state command '%s'", command)
+	}
+}
+
+func runStateList(ctx context.Context, store StateStore, args []string) error {
+	flags := newFlagSet("state list")
+	prefix := flags.String("prefix", "", "key prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := store.List(ctx, *prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+// runStatePrune は保持期間を過ぎた投稿済みURL・HTTP検証子・取得元の稼働状況を削除する。
+// いずれも読み込んで保存し直すと保持期間が適用される。
+func runStatePrune(ctx context.Context, store StateStore, config *Config) error {
+	postedURLs, err := loadPostedURLs(ctx, store, config)
+	if err != nil {
+		return fmt.Errorf("failed to load posted URLs: %w", err)
+	}
+	expired := len(postedURLs) - len(cleanupOldURLs(postedURLs))
+	if err := updatePostedURLs(ctx, store, config, func(current []PostedURL) []PostedURL { return current }); err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d expired posted URLs\n", expired)
+
+	cache, err := loadHTTPCache(ctx, store)
+	if err != nil {
+		return fmt.Errorf("failed to load HTTP cache: %w", err)
+	}
+	if err := cache.save(ctx, store); err != nil {
+		return err
+	}
+
+	health, err := loadHealthTracker(ctx, store)
+	if err != nil {
+		return fmt.Errorf("failed to load source health: %w", err)
+	}
+	if err := health.save(ctx, store); err != nil {
+		return err
+	}
+	fmt.Println("Pruned expired HTTP validators and source health")
+	return nil
+}
+
+func runStateExport(ctx context.Context, store StateStore, args []string) error {
+	flags := newFlagSet("state export")
+	out := flags.String("out", "", "output file (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	export := StateExport{ExportedAt: time.Now().In(time.FixedZone("JST", JSTOffset)).Format(time.RFC3339), Entries: make(map[string]string, len(keys))}
+	for _, key := range keys {
+		// リースは実行中の排他のためのもので、移行先に持ち込まない
+		if key == RunLeaseKey {
+			continue
+		}
+		data, _, err := store.Get(ctx, key)
+		if errors.Is(err, ErrStateNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export '%s': %w", key, err)
+		}
+		export.Entries[key] = string(data)
+	}
+
+	data, err := json.MarshalIndent(export, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state export: %w", err)
+	}
+	if *out == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state export: %w", err)
+	}
+	log.Printf("Exported %d keys to %s", len(export.Entries), *out)
+	return nil
+}
+
+func runStateImport(ctx context.Context, store StateStore, args []string) error {
+	flags := newFlagSet("state import")
+	overwrite := flags.Bool("overwrite", false, "overwrite existing keys")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("state import requires exactly one file")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read state export: %w", err)
+	}
+	var export StateExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("failed to parse state export: %w", err)
+	}
+
+	keys := make([]string, 0, len(export.Entries))
+	for key := range export.Entries {
+		// 一部だけ取り込まれないよう、書き込む前にすべてのキーを確認する
+		if err := validateStateKey(key); err != nil {
+			return fmt.Errorf("refusing to import state export: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	imported := 0
+	for _, key := range keys {
+		if !*overwrite {
+			if _, _, err := store.Get(ctx, key); err == nil {
+				log.Printf("Skipping existing key '%s'", key)
+				continue
+			} else if !errors.Is(err, ErrStateNotFound) {
+				return fmt.Errorf("failed to check '%s': %w", key, err)
+			}
+		}
+
+		if isDryRun(ctx) {
+			log.Printf("DRY RUN: Would import '%s' (%d bytes)", key, len(export.Entries[key]))
+			continue
+		}
+		if err := store.Put(ctx, key, []byte(export.Entries[key])); err != nil {
+			return fmt.Errorf("failed to import '%s': %w", key, err)
+		}
+		imported++
+	}
+
+	fmt.Printf("Imported %d of %d keys\n", imported, len(keys))
+	return nil
+}
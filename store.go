@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	StoreTypeS3   = "s3"
+	StoreTypeFile = "file"
+	StoreTypeBolt = "bolt"
+
+	DefaultPostedURLsKey = "posted_urls.json"
+	DefaultRSSConfigKey  = "rss_config.json"
+	DefaultStoreDir      = "state"
+	DefaultBoltPath      = "kuma_bot.db"
+
+	boltBucketName  = "kuma_bot"
+	boltOpenTimeout = 5 * time.Second
+
+	fileLockRetryInterval = 50 * time.Millisecond
+	fileLockTimeout       = 10 * time.Second
+	fileLockStaleAfter    = time.Minute
+)
+
+var (
+	ErrStateNotFound      = errors.New("state not found")
+	ErrPreconditionFailed = errors.New("state was modified concurrently")
+	ErrInvalidStateKey    = errors.New("invalid state key")
+)
+
+// validateStateKey は保存先の外を指すキー（絶対パスや、正規化後に..を含むキー）を拒否する
+func validateStateKey(key string) error {
+	cleaned := filepath.ToSlash(filepath.Clean(filepath.FromSlash(key)))
+	if key == "" || cleaned == "." || strings.HasPrefix(key, "/") || filepath.IsAbs(key) || filepath.VolumeName(key) != "" {
+		return fmt.Errorf("%w: '%s'", ErrInvalidStateKey, key)
+	}
+	for _, element := range strings.Split(cleaned, "/") {
+		if element == ".." {
+			return fmt.Errorf("%w: '%s'", ErrInvalidStateKey, key)
+		}
+	}
+	return nil
+}
+
+// StateStore は投稿済みURLやRSS設定などのJSONをキー単位で保存するバックエンド
+//
+// Getが返すバージョンをPutIfに渡すと、その間に他の実行が書き込んでいた場合は
+// ErrPreconditionFailedになる。空のバージョンは「キーが存在しないこと」を条件にする。
+type StateStore interface {
+	Get(ctx context.Context, key string) (data []byte, version string, err error)
+	Put(ctx context.Context, key string, data []byte) error
+	PutIf(ctx context.Context, key string, data []byte, version string) (newVersion string, err error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Close() error
+}
+
+func newStateStore(ctx context.Context, appConfig *Config) (StateStore, error) {
+	switch appConfig.Store.Type {
+	case "", StoreTypeS3:
+		return newS3Store(ctx, appConfig)
+	case StoreTypeFile:
+		return newFileStore(appConfig.Store.Path)
+	case StoreTypeBolt:
+		return newBoltStore(appConfig.Store.Path)
+	default:
+		return nil, fmt.Errorf("unknown store type '%s'", appConfig.Store.Type)
+	}
+}
+
+func loadJSON[T any](ctx context.Context, store StateStore, key string, target *T) error {
+	_, err := loadJSONVersioned(ctx, store, key, target)
+	return err
+}
+
+func loadJSONVersioned[T any](ctx context.Context, store StateStore, key string, target *T) (string, error) {
+	data, version, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return "", fmt.Errorf("failed to unmarshal JSON for key '%s': %w", key, err)
+	}
+
+	return version, nil
+}
+
+func saveJSON(ctx context.Context, store StateStore, key string, value any) error {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON for key '%s': %w", key, err)
+	}
+
+	return store.Put(ctx, key, data)
+}
+
+func saveJSONIf(ctx context.Context, store StateStore, key string, value any, version string) (string, error) {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON for key '%s': %w", key, err)
+	}
+
+	return store.PutIf(ctx, key, data, version)
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Store(ctx context.Context, appConfig *Config) (*s3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appConfig.AWS.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return &s3Store{
+		client: s3.NewFromConfig(cfg),
+		bucket: appConfig.AWS.S3.BucketName,
+	}, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, string, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, "", fmt.Errorf("object '%s' not found in S3: %w", key, ErrStateNotFound)
+		}
+		return nil, "", fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	defer result.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(result.Body); err != nil {
+		return nil, "", fmt.Errorf("failed to read object from S3: %w", err)
+	}
+
+	return buf.Bytes(), aws.ToString(result.ETag), nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object to S3: %w", err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) PutIf(ctx context.Context, key string, data []byte, version string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if version == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(version)
+	}
+
+	result, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed", "ConditionalRequestConflict":
+				return "", fmt.Errorf("conditional put of '%s' rejected by S3: %w", key, ErrPreconditionFailed)
+			}
+		}
+		return "", fmt.Errorf("failed to put object to S3: %w", err)
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *s3Store) Close() error {
+	return nil
+}
+
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if dir == "" {
+		dir = DefaultStoreDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory '%s': %w", dir, err)
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(key string) (string, error) {
+	if err := validateStateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *fileStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", fmt.Errorf("file for key '%s' not found: %w", key, ErrStateNotFound)
+		}
+		return nil, "", fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	return data, contentVersion(data), nil
+}
+
+func (s *fileStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return s.write(path, data)
+}
+
+func (s *fileStore) PutIf(ctx context.Context, key string, data []byte, version string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if version != "" {
+			return "", fmt.Errorf("file for key '%s' was removed: %w", key, ErrPreconditionFailed)
+		}
+	case err != nil:
+		return "", fmt.Errorf("failed to read state file: %w", err)
+	case version == "" || contentVersion(current) != version:
+		return "", fmt.Errorf("file for key '%s' was modified: %w", key, ErrPreconditionFailed)
+	}
+
+	if err := s.write(path, data); err != nil {
+		return "", err
+	}
+
+	return contentVersion(data), nil
+}
+
+// lockFile は他プロセスとの排他のためにロックファイルを作成し、解放関数を返す
+func lockFile(ctx context.Context, path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > fileLockStaleAfter {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock file '%s'", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fileLockRetryInterval):
+		}
+	}
+}
+
+func (s *fileStore) write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// 書き込み途中のファイルを読まれないよう一時ファイル経由で置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// List はディレクトリ以下のファイルをキーとして返す。ロックファイルと書き込み途中の一時ファイルは除く。
+func (s *fileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(path, ".lock") || strings.Contains(entry.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state directory: %w", err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	if path == "" {
+		path = DefaultBoltPath
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database '%s': %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltBucketName))
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt bucket: %w", err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(boltBucketName)).Get([]byte(key))
+		if value == nil {
+			return fmt.Errorf("key '%s' not found in bolt database: %w", key, ErrStateNotFound)
+		}
+		data = append([]byte(nil), value...)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, contentVersion(data), nil
+}
+
+func (s *boltStore) Put(ctx context.Context, key string, data []byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltBucketName)).Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put key '%s' to bolt database: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *boltStore) PutIf(ctx context.Context, key string, data []byte, version string) (string, error) {
+	// bboltの書き込みトランザクションは直列化されるため、比較と書き込みを同じトランザクションで行えばよい
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucketName))
+		current := bucket.Get([]byte(key))
+		switch {
+		case current == nil && version != "":
+			return fmt.Errorf("key '%s' was removed: %w", key, ErrPreconditionFailed)
+		case current != nil && (version == "" || contentVersion(current) != version):
+			return fmt.Errorf("key '%s' was modified: %w", key, ErrPreconditionFailed)
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to put key '%s' to bolt database: %w", key, err)
+	}
+
+	return contentVersion(data), nil
+}
+
+func (s *boltStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(boltBucketName)).Cursor()
+		for key, _ := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, _ = cursor.Next() {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys in bolt database: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
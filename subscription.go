@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mattn/go-mastodon"
+)
+
+const (
+	SubscriptionsKey = "subscriptions.json"
+	// SubscriptionMaxPages は1回の実行で読む通知のページ数の上限
+	SubscriptionMaxPages = 5
+	// SubscriptionMaxDirectMessages は1回の実行で購読者に送る通知のDMの上限
+	SubscriptionMaxDirectMessages = 20
+)
+
+var (
+	// subscriptionExcludedNotificationTypes はメンション以外の通知の種類。お気に入りやブーストで読むページが埋まらないよう除外する。
+	subscriptionExcludedNotificationTypes = []string{
+		"follow", "follow_request", "favourite", "reblog", "poll", "status", "update",
+		"admin.sign_up", "admin.report", "severed_relationships", "moderation_warning",
+	}
+	mentionPattern           = regexp.MustCompile(`@\S+`)
+	subscriptionArgSeparator = regexp.MustCompile(`[\s、,・]+`)
+	htmlLineBreakPattern     = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+)
+
+type SubscriptionConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SubscriptionState は都道府県ごとの通知の購読者と、最後に処理した通知のID。
+// Pendingは1回の実行の上限を超えて送れなかった、購読者ごとの通知待ちの記事。
+type SubscriptionState struct {
+	LastNotificationID string                 `json:"last_notification_id"`
+	Subscribers        map[string][]string    `json:"subscribers"`
+	Pending            map[string][]PostedURL `json:"pending,omitempty"`
+	UpdatedAt          time.Time              `json:"updated_at"`
+}
+
+// subscriptionCommand はメンションから読み取った購読の操作
+type subscriptionCommand struct {
+	action      string
+	prefectures []string
+	unknown     []string
+}
+
+func loadSubscriptionState(ctx context.Context, store StateStore) (*SubscriptionState, error) {
+	state := &SubscriptionState{}
+	if err := loadJSON(ctx, store, SubscriptionsKey, state); err != nil && !errors.Is(err, ErrStateNotFound) {
+		return nil, err
+	}
+	if state.Subscribers == nil {
+		state.Subscribers = make(map[string][]string)
+	}
+	return state, nil
+}
+
+func (s *SubscriptionState) save(ctx context.Context, store StateStore) error {
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would save %d subscribers", len(s.Subscribers))
+		return nil
+	}
+
+	s.UpdatedAt = time.Now()
+	if err := saveJSON(ctx, store, SubscriptionsKey, s); err != nil {
+		return fmt.Errorf("failed to save subscriptions: %w", err)
+	}
+	return nil
+}
+
+// processSubscriptionCommands は前回以降のメンションから購読の登録・解除・一覧の操作を読み取り、結果をDMで返信する
+func processSubscriptionCommands(ctx context.Context, store StateStore, client *mastodon.Client) error {
+	state, err := loadSubscriptionState(ctx, store)
+	if err != nil {
+		return fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+
+	// 初回は過去のメンションに今になって返信しないよう、最新の通知のIDを起点として記録するだけにする
+	if state.LastNotificationID == "" {
+		latest, err := client.GetNotificationsExclude(ctx, &subscriptionExcludedNotificationTypes, &mastodon.Pagination{Limit: 1})
+		if err != nil {
+			return fmt.Errorf("failed to fetch notifications: %w", err)
+		}
+		state.LastNotificationID = "0"
+		if len(latest) > 0 {
+			state.LastNotificationID = string(latest[0].ID)
+		}
+		log.Printf("Recorded notification %s as the starting point for subscription commands", state.LastNotificationID)
+		return state.save(ctx, store)
+	}
+
+	notifications, err := fetchMentionsSince(ctx, client, state.LastNotificationID)
+	if err != nil {
+		return err
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	for _, notification := range notifications {
+		state.LastNotificationID = string(notification.ID)
+		if notification.Type != "mention" || notification.Status == nil {
+			continue
+		}
+		command, ok := parseSubscriptionCommand(mentionText(notification.Status.Content))
+		if !ok {
+			continue
+		}
+
+		acct := notification.Account.Acct
+		reply := state.apply(acct, command)
+		log.Printf("Subscription command '%s' from %s", command.action, acct)
+		if err := postDirectMention(ctx, client, acct, reply, notification.Status.ID); err != nil {
+			log.Printf("Failed to reply to %s: %v", acct, err)
+		}
+	}
+
+	return state.save(ctx, store)
+}
+
+// fetchMentionsSince はsinceIDより後の通知を古い順に返す
+func fetchMentionsSince(ctx context.Context, client *mastodon.Client, sinceID string) ([]*mastodon.Notification, error) {
+	var all []*mastodon.Notification
+	minID := sinceID
+	for page := 0; page < SubscriptionMaxPages; page++ {
+		notifications, err := client.GetNotificationsExclude(ctx, &subscriptionExcludedNotificationTypes, &mastodon.Pagination{
+			MinID: mastodon.ID(minID),
+			Limit: TootFetchLimit,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch notifications: %w", err)
+		}
+		if len(notifications) == 0 {
+			break
+		}
+
+		sort.Slice(notifications, func(i, j int) bool {
+			return compareStatusIDs(string(notifications[i].ID), string(notifications[j].ID)) < 0
+		})
+		all = append(all, notifications...)
+		minID = string(notifications[len(notifications)-1].ID)
+	}
+	return all, nil
+}
+
+// compareStatusIDs は数字の文字列のIDを数値として比較する
+func compareStatusIDs(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// mentionText は投稿のHTMLから改行を保ったテキストを取り出す
+func mentionText(content string) string {
+	content = htmlLineBreakPattern.ReplaceAllString(content, "\n")
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+	return doc.Text()
+}
+
+// parseSubscriptionCommand はメンションを除いた最初の行の最初の語を操作、残りを都道府県として読み取る
+func parseSubscriptionCommand(text string) (subscriptionCommand, bool) {
+	line, _, _ := strings.Cut(strings.TrimSpace(mentionPattern.ReplaceAllString(text, " ")), "\n")
+	words := subscriptionArgSeparator.Split(strings.TrimSpace(line), -1)
+	if len(words) == 0 {
+		return subscriptionCommand{}, false
+	}
+
+	var command subscriptionCommand
+	switch strings.ToLower(words[0]) {
+	case "subscribe", "登録":
+		command.action = "subscribe"
+	case "unsubscribe", "解除":
+		command.action = "unsubscribe"
+	case "list", "一覧":
+		command.action = "list"
+		return command, true
+	default:
+		return subscriptionCommand{}, false
+	}
+
+	for _, word := range words[1:] {
+		if word == "" {
+			continue
+		}
+		if prefecture, ok := normalizePrefecture(word); ok {
+			command.prefectures = append(command.prefectures, prefecture)
+		} else if strings.ToLower(word) != "all" && word != "すべて" {
+			command.unknown = append(command.unknown, word)
+		}
+	}
+	return command, true
+}
+
+// normalizePrefecture は「岩手県」「岩手」のどちらも都道府県名として返す
+func normalizePrefecture(name string) (string, bool) {
+	for _, prefecture := range prefectures {
+		if name == prefecture || name == prefectureAbbreviation(prefecture) {
+			return prefecture, true
+		}
+	}
+	return "", false
+}
+
+// apply は購読の操作を適用し、返信の本文を返す。都道府県を指定しない解除はすべての購読を解除する。
+func (s *SubscriptionState) apply(acct string, command subscriptionCommand) string {
+	current := s.Subscribers[acct]
+	var message string
+	switch command.action {
+	case "subscribe":
+		if len(command.prefectures) == 0 {
+			return withUnknownPrefectures("登録する都道府県を指定してください（例: subscribe 岩手県）", command.unknown)
+		}
+		for _, prefecture := range command.prefectures {
+			if !slices.Contains(current, prefecture) {
+				current = append(current, prefecture)
+			}
+		}
+		message = strings.Join(command.prefectures, "・") + "のクマ出没情報をお知らせします"
+	case "unsubscribe":
+		if len(command.prefectures) == 0 && len(command.unknown) > 0 {
+			return withUnknownPrefectures("解除する都道府県を指定してください（例: unsubscribe 岩手県）", command.unknown)
+		}
+		if len(command.prefectures) == 0 {
+			current = nil
+			message = "すべての通知を解除しました"
+		} else {
+			current = slices.DeleteFunc(current, func(prefecture string) bool {
+				return slices.Contains(command.prefectures, prefecture)
+			})
+			message = strings.Join(command.prefectures, "・") + "の通知を解除しました"
+		}
+	case "list":
+		message = "通知の登録はありません"
+		if len(current) > 0 {
+			message = "通知中の都道府県: " + strings.Join(current, "・")
+		}
+		return message
+	}
+
+	if len(current) == 0 {
+		delete(s.Subscribers, acct)
+	} else {
+		sort.Slice(current, func(i, j int) bool {
+			return slices.Index(prefectures, current[i]) < slices.Index(prefectures, current[j])
+		})
+		s.Subscribers[acct] = current
+		message += "（通知中: " + strings.Join(current, "・") + "）"
+	}
+	return withUnknownPrefectures(message, command.unknown)
+}
+
+func withUnknownPrefectures(message string, unknown []string) string {
+	if len(unknown) == 0 {
+		return message
+	}
+	return message + "\n都道府県名として認識できませんでした: " + strings.Join(unknown, "、")
+}
+
+// subscriberNotification は1人の購読者に送る、その実行で該当した記事の通知
+type subscriberNotification struct {
+	acct        string
+	prefectures []string
+	articles    []PostedURL
+}
+
+// notifySubscribers は投稿した記事の都道府県を購読しているアカウントに、該当した記事をまとめたDMを1通ずつ送る。
+// 投稿数の制限にかからないよう、1回の実行で送るDMはSubscriptionMaxDirectMessages通までとし、
+// 超えた分と送信に失敗した分は通知待ちとして保存して次の実行で先に送る。
+func notifySubscribers(ctx context.Context, store StateStore, config *Config, client *mastodon.Client, articles []PostedURL) {
+	if !config.Subscriptions.Enabled {
+		return
+	}
+
+	state, err := loadSubscriptionState(ctx, store)
+	if err != nil {
+		log.Printf("Failed to load subscriptions: %v", err)
+		return
+	}
+	if len(articles) == 0 && len(state.Pending) == 0 {
+		return
+	}
+
+	// 前回送れなかった購読者を先に、残りはアカウント順に送る
+	accounts := make([]string, 0, len(state.Subscribers))
+	for acct := range state.Subscribers {
+		accounts = append(accounts, acct)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		_, pendingI := state.Pending[accounts[i]]
+		_, pendingJ := state.Pending[accounts[j]]
+		if pendingI != pendingJ {
+			return pendingI
+		}
+		return accounts[i] < accounts[j]
+	})
+
+	var notifications []subscriberNotification
+	for _, acct := range accounts {
+		notifications = appendSubscriberNotification(notifications, acct, state.Subscribers[acct], append(state.Pending[acct], articles...))
+	}
+
+	// 購読を解除したアカウントや都道府県の通知待ちは、照合し直した結果に含まれないので破棄される
+	state.Pending = make(map[string][]PostedURL)
+	if len(notifications) > SubscriptionMaxDirectMessages {
+		var deferred []string
+		for _, notification := range notifications[SubscriptionMaxDirectMessages:] {
+			state.Pending[notification.acct] = notification.articles
+			deferred = append(deferred, fmt.Sprintf("%s (%d articles)", notification.acct, len(notification.articles)))
+		}
+		log.Printf("Deferring notifications to %d subscribers over the limit of %d per run: %s",
+			len(deferred), SubscriptionMaxDirectMessages, strings.Join(deferred, ", "))
+		notifications = notifications[:SubscriptionMaxDirectMessages]
+	}
+
+	for i, notification := range notifications {
+		if i > 0 {
+			time.Sleep(PostDelay)
+		}
+
+		// 記事が1件の場合はその記事の投稿への返信にする
+		var inReplyTo mastodon.ID
+		idempotencyKey := notification.acct
+		for _, article := range notification.articles {
+			idempotencyKey += " " + article.URL
+		}
+		if len(notification.articles) == 1 {
+			inReplyTo = mastodon.ID(notification.articles[0].StatusID)
+		}
+
+		message := formatSubscriberNotification(notification)
+		if err := postDirectMention(withIdempotencyKey(ctx, idempotencyKey), client, notification.acct, message, inReplyTo); err != nil {
+			log.Printf("Failed to notify %s of %d articles, will retry next run: %v", notification.acct, len(notification.articles), err)
+			state.Pending[notification.acct] = notification.articles
+		}
+	}
+
+	if err := state.save(ctx, store); err != nil {
+		log.Printf("Failed to save pending subscription notifications: %v", err)
+	}
+}
+
+// appendSubscriberNotification はarticlesのうちprefecturesに該当する記事（URLの重複を除く）をまとめた通知を追加する
+func appendSubscriberNotification(notifications []subscriberNotification, acct string, prefectures []string, articles []PostedURL) []subscriberNotification {
+	notification := subscriberNotification{acct: acct}
+	seenURLs := make(map[string]struct{})
+	for _, article := range articles {
+		if _, exists := seenURLs[article.URL]; exists {
+			continue
+		}
+		articlePrefs := articlePrefectures(article)
+		matched := false
+		for _, prefecture := range prefectures {
+			if !slices.Contains(articlePrefs, prefecture) {
+				continue
+			}
+			matched = true
+			if !slices.Contains(notification.prefectures, prefecture) {
+				notification.prefectures = append(notification.prefectures, prefecture)
+			}
+		}
+		if matched {
+			notification.articles = append(notification.articles, article)
+			seenURLs[article.URL] = struct{}{}
+		}
+	}
+	if len(notification.articles) == 0 {
+		return notifications
+	}
+	return append(notifications, notification)
+}
+
+// formatSubscriberNotification は記事のタイトルとURLを並べた通知の本文を作る。
+// メンションを含めてMaxPostRunes文字に収まらない記事は「ほかN件」とまとめる。
+func formatSubscriberNotification(notification subscriberNotification) string {
+	message := fmt.Sprintf("📍 %sのクマ出没情報（%d件）", strings.Join(notification.prefectures, "・"), len(notification.articles))
+	limit := MaxPostRunes - utf8.RuneCountInString("@"+notification.acct+" ")
+	reserve := utf8.RuneCountInString(fmt.Sprintf("\n\nほか%d件", len(notification.articles)))
+
+	listed := 0
+	for _, article := range notification.articles {
+		entry := "\n\n" + article.Title + "\n" + article.URL
+		if utf8.RuneCountInString(message+entry)+reserve > limit {
+			break
+		}
+		message += entry
+		listed++
+	}
+	if rest := len(notification.articles) - listed; rest > 0 {
+		message += fmt.Sprintf("\n\nほか%d件", rest)
+	}
+	return message
+}
+
+// postDirectMention はacctだけに見えるメンションを送る。inReplyToを指定するとその投稿への返信にする。
+func postDirectMention(ctx context.Context, client *mastodon.Client, acct, message string, inReplyTo mastodon.ID) error {
+	content := fmt.Sprintf("@%s %s", acct, message)
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would send direct mention:\n%s", content)
+		return nil
+	}
+
+	_, err := client.PostStatus(ctx, &mastodon.Toot{
+		Status:      content,
+		InReplyToID: inReplyTo,
+		Visibility:  "direct",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send direct mention: %w", err)
+	}
+	return nil
+}
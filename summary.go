@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mattn/go-mastodon"
+)
+
+// TimelineSource はタイムラインから補完したアーカイブ記録の取得元名
+const TimelineSource = "timeline"
+
+var (
+	prefectureRegex = regexp.MustCompile(prefecturePattern)
+	lineBreakTags   = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n\n")
+	articlePrefixes = []string{"🐻 ", "📰 クマ関連ニュース："}
+)
+
+// postedLocation は投稿本文の📍行（地域）を返す。📍行のない投稿は空文字を返す。
+func postedLocation(content string) string {
+	matches := prefectureRegex.FindStringSubmatch(content)
+	if len(matches) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(matches[1])
+}
+
+// loadSummaryRecords は集計対象日のアーカイブを読み込む。アーカイブがない日（アーカイブ導入前など）は
+// 自アカウントのタイムラインから記録を復元し、アーカイブに補完してから返す。
+func loadSummaryRecords(ctx context.Context, store StateStore, client *mastodon.Client, date time.Time) ([]ArchiveRecord, error) {
+	records, err := loadArchiveRecords(ctx, store, date)
+	if err == nil {
+		return records, nil
+	}
+	if !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load archive records: %w", err)
+	}
+
+	log.Printf("Archive for %s not found, backfilling from timeline", date.Format(ArchiveDateFormat))
+	return backfillArchiveFromTimeline(ctx, store, client, date)
+}
+
+func backfillArchiveFromTimeline(ctx context.Context, store StateStore, client *mastodon.Client, date time.Time) ([]ArchiveRecord, error) {
+	dayEnd := date.AddDate(0, 0, 1)
+	toots, err := fetchRecentToots(ctx, client, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent toots: %w", err)
+	}
+
+	var records []ArchiveRecord
+	for _, toot := range toots {
+		if !toot.CreatedAt.Before(dayEnd) {
+			continue
+		}
+		if record, ok := archiveRecordFromToot(toot); ok {
+			records = append(records, record)
+		}
+	}
+
+	// 取得順は新しい順なので、アーカイブは投稿順に並べて保存する
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].PostedAt.Before(records[j].PostedAt)
+	})
+
+	if len(records) > 0 {
+		if err := appendArchiveRecords(ctx, store, records); err != nil {
+			log.Printf("Failed to save backfilled archive records: %v", err)
+		}
+	}
+
+	return records, nil
+}
+
+// archiveRecordFromToot は記事の投稿からアーカイブ記録を復元する。
+// 記事へのリンクがない投稿（集計・通知）と返信（類似記事）は対象外。
+func archiveRecordFromToot(toot *mastodon.Status) (ArchiveRecord, bool) {
+	if toot.InReplyToID != nil {
+		return ArchiveRecord{}, false
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(lineBreakTags.Replace(toot.Content)))
+	if err != nil {
+		return ArchiveRecord{}, false
+	}
+
+	articleURL, ok := doc.Find("a:not(.mention):not(.hashtag)").First().Attr("href")
+	if !ok {
+		return ArchiveRecord{}, false
+	}
+
+	text := doc.Text()
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	for _, prefix := range articlePrefixes {
+		title = strings.TrimPrefix(title, prefix)
+	}
+
+	record := ArchiveRecord{
+		URL:         articleURL,
+		Title:       strings.TrimSpace(title),
+		Source:      TimelineSource,
+		Location:    postedLocation(text),
+		StatusID:    string(toot.ID),
+		PublishedAt: toot.CreatedAt,
+		PostedAt:    toot.CreatedAt,
+	}
+	if record.Location != "" {
+		record.Prefectures = extractPrefectures(record.Location)
+		if len(record.Prefectures) > 0 {
+			record.Prefecture = record.Prefectures[0]
+		}
+	}
+
+	return record, true
+}
+
+// aggregateArchiveRecords は📍行付きで投稿した記事を都道府県別に数える。
+// 複数の都道府県を含む記事はそれぞれに数え、類似記事として投稿しなかった記事・返信した記事は数えない。
+func aggregateArchiveRecords(records []ArchiveRecord) ([]PrefectureCount, int) {
+	prefectureCountMap := make(map[string]int)
+	var totalCount int
+	var otherCount int
+	for _, record := range records {
+		if record.DuplicateOf != "" {
+			continue
+		}
+
+		recordPrefectures := record.Prefectures
+		if len(recordPrefectures) == 0 && record.Prefecture != "" {
+			recordPrefectures = []string{record.Prefecture}
+		}
+		// Locationを記録する前のアーカイブは、都道府県が分かる記録だけを対象にする
+		if record.Location == "" && len(recordPrefectures) == 0 {
+			continue
+		}
+
+		for _, prefecture := range recordPrefectures {
+			prefectureCountMap[prefecture]++
+		}
+		if len(recordPrefectures) == 0 {
+			otherCount++
+		}
+		totalCount++
+	}
+
+	var results []PrefectureCount
+	for prefecture, count := range prefectureCountMap {
+		results = append(results, PrefectureCount{
+			Prefecture: prefecture,
+			Count:      count,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Count == results[j].Count {
+			return results[i].Prefecture < results[j].Prefecture
+		}
+		return results[i].Count > results[j].Count
+	})
+
+	if otherCount > 0 {
+		results = append(results, PrefectureCount{
+			Prefecture: OtherPrefecture,
+			Count:      otherCount,
+		})
+	}
+
+	return results, totalCount
+}
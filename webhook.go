@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookFormatDiscord = "discord"
+	WebhookFormatSlack   = "slack"
+	WebhookFormatJSON    = "json"
+	WebhookMaxAttempts   = 3
+	WebhookRetryDelay    = 2 * time.Second
+	// WebhookMaxConsecutiveFailures は送信先を諦めるまでの連続した失敗の件数。諦めた送信先にはその実行の残りの記事を送らない。
+	WebhookMaxConsecutiveFailures = 2
+	// WebhookDeadline はすべての送信先への送信にかける時間の上限。過ぎた分は送らずに諦める。
+	WebhookDeadline = 2 * time.Minute
+	// webhookMaxRetryAfter はRetry-Afterに従って待つ最大時間。これより長い指定は待たずに諦める。
+	webhookMaxRetryAfter = 30 * time.Second
+	discordEmbedColor    = 0x8b4513
+	discordMaxTitle      = 256
+	discordMaxDesc       = 4096
+	slackMaxSectionText  = 3000
+)
+
+// WebhookConfig は記事を送るWebhookの送信先。Prefecturesを指定するとその都道府県の記事だけを送る。
+type WebhookConfig struct {
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	Format      string   `json:"format"`
+	Prefectures []string `json:"prefectures"`
+}
+
+func (w WebhookConfig) label() string {
+	if w.Name != "" {
+		return w.Name
+	}
+	return w.URL
+}
+
+// matches は記事がWebhookの都道府県の条件に合うかを返す。都道府県の分からない記事は条件のない送信先にだけ送る。
+func (w WebhookConfig) matches(article PostedURL) bool {
+	if len(w.Prefectures) == 0 {
+		return true
+	}
+	for _, prefecture := range articlePrefectures(article) {
+		if slices.Contains(w.Prefectures, prefecture) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookError はWebhookの送信の失敗。retryがtrueの場合は時間をおいて再送する。
+type webhookError struct {
+	err        error
+	retry      bool
+	retryAfter time.Duration
+}
+
+func (e *webhookError) Error() string {
+	return e.err.Error()
+}
+
+// sendWebhooks は投稿した記事を送信先ごとに並行して送る。送信先の失敗は他の送信先や投稿に影響しない。
+// 応答しない送信先で実行が長引かないよう、連続して失敗した送信先はその実行では諦め、全体をWebhookDeadlineで打ち切る。
+func sendWebhooks(ctx context.Context, config *Config, articles []PostedURL) {
+	if len(config.Webhooks) == 0 || len(articles) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, WebhookDeadline)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, webhook := range config.Webhooks {
+		var matched []PostedURL
+		for _, article := range articles {
+			if webhook.matches(article) {
+				matched = append(matched, article)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sent, failures := 0, 0
+			for i, article := range matched {
+				if failures >= WebhookMaxConsecutiveFailures {
+					log.Printf("Giving up on webhook '%s' after %d consecutive failures, skipping %d articles", webhook.label(), failures, len(matched)-i)
+					break
+				}
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(PostDelay):
+					}
+				}
+				if ctx.Err() != nil {
+					log.Printf("Webhook deadline exceeded, skipping %d articles for webhook '%s'", len(matched)-i, webhook.label())
+					break
+				}
+				if err := sendWebhook(ctx, webhook, article); err != nil {
+					log.Printf("Failed to send '%s' to webhook '%s': %v", article.Title, webhook.label(), err)
+					failures++
+					continue
+				}
+				sent++
+				failures = 0
+			}
+			log.Printf("Sent %d of %d articles to webhook '%s'", sent, len(matched), webhook.label())
+		}()
+	}
+	wg.Wait()
+}
+
+// sendWebhook は1件の記事を送り、再送できる失敗はWebhookMaxAttempts回まで再送する
+func sendWebhook(ctx context.Context, webhook WebhookConfig, article PostedURL) error {
+	payload, err := webhookPayload(webhook.Format, article)
+	if err != nil {
+		return err
+	}
+	if isDryRun(ctx) {
+		log.Printf("DRY RUN: Would send webhook '%s':\n%s", webhook.label(), payload)
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(ctx, webhook.URL, payload)
+		if err == nil {
+			return nil
+		}
+		var webhookErr *webhookError
+		if !errors.As(err, &webhookErr) || !webhookErr.retry || attempt >= WebhookMaxAttempts {
+			return err
+		}
+
+		delay := time.Duration(attempt) * WebhookRetryDelay
+		if webhookErr.retryAfter > 0 {
+			if webhookErr.retryAfter > webhookMaxRetryAfter {
+				return err
+			}
+			delay = webhookErr.retryAfter
+		}
+		log.Printf("Webhook '%s' failed, retrying in %s (attempt %d/%d): %v", webhook.label(), delay, attempt, WebhookMaxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, webhookURL string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: HTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &webhookError{err: fmt.Errorf("failed to post webhook: %w", err), retry: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	webhookErr := &webhookError{
+		err:   fmt.Errorf("webhook returned status code: %d", resp.StatusCode),
+		retry: resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		webhookErr.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return webhookErr
+}
+
+// webhookPayload は送信先の形式に合わせた本文を作る
+func webhookPayload(format string, article PostedURL) ([]byte, error) {
+	var payload any
+	switch format {
+	case WebhookFormatDiscord:
+		payload = discordPayload(article)
+	case WebhookFormatSlack:
+		payload = slackPayload(article)
+	case "", WebhookFormatJSON:
+		payload = article
+	default:
+		return nil, fmt.Errorf("unknown webhook format '%s'", format)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	return data, nil
+}
+
+// articleDescription は記事の概要から投稿用の装飾を除いた本文を返す
+func articleDescription(article PostedURL) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(article.Description), "🔗"))
+}
+
+func discordPayload(article PostedURL) map[string]any {
+	embed := map[string]any{
+		"title":       truncateRunes(article.Title, discordMaxTitle),
+		"url":         article.URL,
+		"description": truncateRunes(articleDescription(article), discordMaxDesc),
+		"color":       discordEmbedColor,
+	}
+	var fields []map[string]any
+	if prefectures := articlePrefectures(article); len(prefectures) > 0 {
+		fields = append(fields, map[string]any{"name": "都道府県", "value": strings.Join(prefectures, "・"), "inline": true})
+	}
+	if article.Location != "" {
+		fields = append(fields, map[string]any{"name": "場所", "value": article.Location, "inline": true})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+	if article.Source != "" {
+		embed["footer"] = map[string]string{"text": article.Source}
+	}
+	if !article.PublishedAt.IsZero() {
+		embed["timestamp"] = article.PublishedAt.Format(time.RFC3339)
+	}
+	return map[string]any{"embeds": []any{embed}}
+}
+
+func slackPayload(article PostedURL) map[string]any {
+	text := fmt.Sprintf("*<%s|%s>*", article.URL, escapeSlackText(article.Title))
+	if description := articleDescription(article); description != "" {
+		text += "\n" + escapeSlackText(description)
+	}
+
+	var details []string
+	if prefectures := articlePrefectures(article); len(prefectures) > 0 {
+		details = append(details, "📍 "+strings.Join(prefectures, "・"))
+	}
+	if article.Source != "" {
+		details = append(details, article.Source)
+	}
+
+	blocks := []map[string]any{{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": truncateRunes(text, slackMaxSectionText)},
+	}}
+	if len(details) > 0 {
+		blocks = append(blocks, map[string]any{
+			"type":     "context",
+			"elements": []map[string]string{{"type": "mrkdwn", "text": escapeSlackText(strings.Join(details, " | "))}},
+		})
+	}
+	// textは通知やブロックを表示できないクライアント向けの代替表示
+	return map[string]any{"text": article.Title + " " + article.URL, "blocks": blocks}
+}
+
+func escapeSlackText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}